@@ -0,0 +1,100 @@
+package tres
+
+import (
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+)
+
+// ListCards returns the cards of a list, filter is open, closed or all.
+func (client *TrelloClient) ListCards(listID, filter string) ([]*TrelloCardSearchResult, error) {
+	theURL := client.prepareQuery("/1/lists/"+strings.TrimSpace(listID)+"/cards/"+filter, map[string]string{})
+	result := []*TrelloCardSearchResult{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// listFromName resolves a list name or ID to the list ID, on the given board
+// or on all boards if boardName is empty. A name found on several boards is
+// an error, the board has to be given then.
+func (client *TrelloClient) listFromName(boardName, list string) (string, error) {
+	found := []string{}
+	for name, lists := range client.TrelloLists {
+		if boardName != "" && name != strings.ToLower(boardName) {
+			continue
+		}
+		if id := IDFromName(list, lists); id != "" {
+			found = append(found, id)
+		} else if NameFromID(list, lists) != "" {
+			found = append(found, list)
+		}
+	}
+	switch {
+	case len(found) == 0 && boardName != "":
+		return "", errors.New("Unknown list " + list + " on board " + boardName)
+	case len(found) == 0:
+		return "", errors.New("Unknown list " + list)
+	case len(found) > 1:
+		return "", errors.New("List " + list + " is on several boards, use --board")
+	}
+	return found[0], nil
+}
+
+// Cards lists the open cards of a board, or of a list with --list, straight
+// from the boards API. Unlike search it sees new and moved cards at once and
+// returns all cards, not only the first 1000.
+func (client *TrelloClient) Cards() error {
+	var cards []*TrelloCardSearchResult
+	board := client.config.BoardName
+	if flag.NArg() >= 2 {
+		board = flag.Arg(flag.NArg() - 1)
+	}
+	boardID, boardName := "", ""
+	var err error
+	if board != "" || client.config.ListName == "" {
+		boardID, boardName, err = client.boardFromName(board)
+		if err != nil {
+			return err
+		}
+	}
+	if client.config.ListName != "" {
+		var listID string
+		listID, err = client.listFromName(boardName, client.config.ListName)
+		if err != nil {
+			return err
+		}
+		cards, err = client.ListCards(listID, "open")
+	} else {
+		cards, err = client.BoardCards(boardID, "open")
+	}
+	if err == nil {
+		cards, err = client.applyWhere(cards)
+	}
+	if err != nil {
+		return errors.New("Could not read cards: " + err.Error())
+	}
+
+	if strings.TrimSpace(client.config.SortFields) == "" {
+		// board order, lists as on the board and cards by position in their
+		// list; with --sort outputCards sorts
+		order := map[string]int{}
+		for _, lists := range client.TrelloLists {
+			for i, list := range lists {
+				order[list.ID] = i
+			}
+		}
+		sort.SliceStable(cards, func(i, j int) bool {
+			if cards[i].IDList != cards[j].IDList {
+				return order[cards[i].IDList] < order[cards[j].IDList]
+			}
+			return cards[i].Pos < cards[j].Pos
+		})
+	}
+
+	if client.config.Aggregate {
+		return client.outputStats(cards, client.config.Format)
+	}
+	return client.outputCards(cards, client.config.Format)
+}
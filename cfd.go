@@ -0,0 +1,530 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// TimeSeries is a table of daily values, one column per series.
+type TimeSeries struct {
+	Title  string           `json:"title"`
+	Series []string         `json:"series"`
+	Rows   []*TimeSeriesRow `json:"rows"`
+}
+
+type TimeSeriesRow struct {
+	Date   string    `json:"date"`
+	Values []float64 `json:"values"` // in the order of Series
+}
+
+// boardEvent puts a card into a list (list != ""), archives or removes it
+type boardEvent struct {
+	at      time.Time
+	card    string
+	list    string
+	remove  bool
+	archive bool
+	restore bool
+}
+
+// boardHistory knows where every card of a board was at any point in time
+type boardHistory struct {
+	lists  []*TrelloList
+	cards  map[string]*TrelloCardSearchResult
+	events []*boardEvent
+}
+
+func (client *TrelloClient) loadBoardHistory(boardID string) (*boardHistory, error) {
+	lists, err := client.BoardLists(boardID, "all")
+	if err != nil {
+		return nil, err
+	}
+	actions, err := client.BoardActions(boardID, listActionFilter, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	cards, err := client.BoardCards(boardID, "all")
+	if err != nil {
+		return nil, err
+	}
+	h := &boardHistory{lists: lists, cards: make(map[string]*TrelloCardSearchResult)}
+	for _, card := range cards {
+		h.cards[card.ID] = card
+	}
+
+	seen := map[string]bool{}
+	for _, action := range actions {
+		id := action.Data.Card.IDCard
+		at, ok := parseTrelloDate(action.Date)
+		if id == "" || !ok {
+			continue
+		}
+		event := &boardEvent{at: at, card: id}
+		switch {
+		case action.Type == "moveCardFromBoard" || action.Type == "deleteCard":
+			event.remove = true
+		case action.Type == "updateCard" && action.Data.Old["closed"] != nil:
+			if action.Data.Card.Closed {
+				event.archive = true
+			} else {
+				event.restore = true
+			}
+		default:
+			list, ok := actionList(action)
+			if !ok {
+				continue
+			}
+			event.list = list.IDList
+		}
+		if !seen[id] {
+			seen[id] = true
+			// the card existed before its first recorded move
+			created, _ := cardCreated(id)
+			if before := action.Data.ListBefore.IDList; before != "" {
+				h.events = append(h.events, &boardEvent{at: created, card: id, list: before})
+			} else if event.archive || event.remove {
+				if card, ok := h.cards[id]; ok {
+					h.events = append(h.events, &boardEvent{at: created, card: id, list: card.IDList})
+				}
+			}
+		}
+		h.events = append(h.events, event)
+	}
+	// cards that never moved
+	for _, card := range cards {
+		if seen[card.ID] {
+			continue
+		}
+		created, _ := cardCreated(card.ID)
+		h.events = append(h.events, &boardEvent{at: created, card: card.ID, list: card.IDList})
+		if card.Closed {
+			last, _ := parseTrelloDate(card.DateLastActivity)
+			h.events = append(h.events, &boardEvent{at: last, card: card.ID, archive: true})
+		}
+	}
+	sort.SliceStable(h.events, func(i, j int) bool {
+		return h.events[i].at.Before(h.events[j].at)
+	})
+	return h, nil
+}
+
+// dailyTotals replays the events and sums the weight of the cards per list at
+// the end of every day from the first to the last day
+func (h *boardHistory) dailyTotals(from, to time.Time, weight func(cardID string) float64) ([]time.Time, []map[string]float64) {
+	type state struct {
+		list   string
+		closed bool
+	}
+	states := map[string]*state{}
+	days := []time.Time{}
+	totals := []map[string]float64{}
+	next := 0
+	for day := startOfDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		end := day.AddDate(0, 0, 1)
+		for ; next < len(h.events) && h.events[next].at.Before(end); next++ {
+			e := h.events[next]
+			s, ok := states[e.card]
+			if !ok {
+				s = &state{}
+				states[e.card] = s
+			}
+			switch {
+			case e.remove:
+				delete(states, e.card)
+			case e.archive:
+				s.closed = true
+			case e.restore:
+				s.closed = false
+			default:
+				s.list = e.list
+			}
+		}
+		counts := map[string]float64{}
+		for card, s := range states {
+			if !s.closed && s.list != "" {
+				counts[s.list] += weight(card)
+			}
+		}
+		days = append(days, day)
+		totals = append(totals, counts)
+	}
+	return days, totals
+}
+
+// pointsFunc returns the weight of a card for --points cards, checkitems or
+// cf:<name> (a number custom field). Cards that no longer exist count as one
+// card and zero points.
+func (client *TrelloClient) pointsFunc(cards map[string]*TrelloCardSearchResult) (func(string) float64, string, error) {
+	points := strings.TrimSpace(client.config.Points)
+	switch {
+	case points == "" || strings.EqualFold(points, "cards"):
+		return func(string) float64 { return 1 }, "cards", nil
+	case strings.EqualFold(points, "checkitems"):
+		return func(id string) float64 {
+			if card, ok := cards[id]; ok && card.Badges != nil {
+				return float64(card.Badges.CheckItems)
+			}
+			return 0
+		}, "checklist items", nil
+	case isCustomField(strings.ToLower(points)):
+		name := customFieldName(points)
+		return func(id string) float64 {
+			if card, ok := cards[id]; ok {
+				if _, val := client.customFieldValue(card, name); val.kind == kindNumber && !val.missing {
+					return val.num
+				}
+			}
+			return 0
+		}, name, nil
+	}
+	return nil, "", errors.New("Invalid --points '" + points + "', use cards, checkitems or cf:<name>")
+}
+
+// reportPeriod returns --from and --to, by default the last 30 days
+func (client *TrelloClient) reportPeriod() (time.Time, time.Time, error) {
+	now := client.Now()
+	from, to := startOfDay(now).AddDate(0, 0, -30), now
+	if s := strings.TrimSpace(client.config.From); s != "" {
+		d, err := parseDateExpr(s)
+		if err != nil {
+			return from, to, err
+		}
+		from = d.resolve(now)
+	}
+	if s := strings.TrimSpace(client.config.To); s != "" {
+		d, err := parseDateExpr(s)
+		if err != nil {
+			return from, to, err
+		}
+		to = d.resolve(now)
+	}
+	if to.Before(from) {
+		return from, to, errors.New("--to is before --from")
+	}
+	return from, to, nil
+}
+
+func (client *TrelloClient) cumulativeFlow(boardID, boardName string) (*TimeSeries, error) {
+	from, to, err := client.reportPeriod()
+	if err != nil {
+		return nil, err
+	}
+	h, err := client.loadBoardHistory(boardID)
+	if err != nil {
+		return nil, err
+	}
+	weight, unit, err := client.pointsFunc(h.cards)
+	if err != nil {
+		return nil, err
+	}
+	days, totals := h.dailyTotals(from, to, weight)
+
+	// open lists and closed lists that had cards during the period, right to
+	// left so the done column is at the bottom of the chart
+	ts := &TimeSeries{Title: "Cumulative flow of " + boardName + " (" + unit + ")"}
+	columns := []string{}
+	for i := len(h.lists) - 1; i >= 0; i-- {
+		list := h.lists[i]
+		used := !list.Closed
+		for _, counts := range totals {
+			used = used || counts[list.IDList] != 0
+		}
+		if used {
+			ts.Series = append(ts.Series, list.ListName)
+			columns = append(columns, list.IDList)
+		}
+	}
+	for i, day := range days {
+		row := &TimeSeriesRow{Date: day.Format("2006-01-02")}
+		for _, id := range columns {
+			row.Values = append(row.Values, totals[i][id])
+		}
+		ts.Rows = append(ts.Rows, row)
+	}
+	return ts, nil
+}
+
+func (client *TrelloClient) burndown(boardID, boardName string) (*TimeSeries, error) {
+	if client.config.ListName == "" {
+		return nil, errors.New("Missing --list with the name of the done list")
+	}
+	from, to, err := client.reportPeriod()
+	if err != nil {
+		return nil, err
+	}
+	h, err := client.loadBoardHistory(boardID)
+	if err != nil {
+		return nil, err
+	}
+	doneIdx := listIndex(h.lists, client.config.ListName)
+	if doneIdx < 0 {
+		return nil, errors.New("Unknown list " + client.config.ListName + " on board " + boardName)
+	}
+	weight, unit, err := client.pointsFunc(h.cards)
+	if err != nil {
+		return nil, err
+	}
+	days, totals := h.dailyTotals(from, to, weight)
+
+	ts := &TimeSeries{Title: "Burndown of " + boardName + " (" + unit + ")", Series: []string{"remaining", "done", "ideal"}}
+	for i, day := range days {
+		remaining, done := 0.0, 0.0
+		for list, v := range totals[i] {
+			// lists right of the done list count as done as well
+			if listIndexByID(h.lists, list) >= doneIdx {
+				done += v
+			} else {
+				remaining += v
+			}
+		}
+		ts.Rows = append(ts.Rows, &TimeSeriesRow{Date: day.Format("2006-01-02"), Values: []float64{remaining, done, 0}})
+	}
+	if n := len(ts.Rows); n > 0 {
+		start := ts.Rows[0].Values[0]
+		for i, row := range ts.Rows {
+			ideal := start
+			if n > 1 {
+				ideal = start * float64(n-1-i) / float64(n-1)
+			}
+			row.Values[2] = math.Round(ideal*100) / 100
+		}
+	}
+	return ts, nil
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (ts *TimeSeries) table() [][]string {
+	rows := [][]string{append([]string{"date"}, ts.Series...)}
+	for _, row := range ts.Rows {
+		cols := []string{row.Date}
+		for _, v := range row.Values {
+			cols = append(cols, formatValue(v))
+		}
+		rows = append(rows, cols)
+	}
+	return rows
+}
+
+func (client *TrelloClient) timeSeriesFormatterText(ts *TimeSeries) error {
+	rows := ts.table()
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, col := range row {
+			if n := len([]rune(col)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	fmt.Fprintln(client.out, ts.Title)
+	fmt.Fprintln(client.out)
+	for _, row := range rows {
+		fmt.Fprintln(client.out, strings.TrimRight(strings.Join(formatColumns(row, widths), "  "), " "))
+	}
+	return nil
+}
+
+func (client *TrelloClient) timeSeriesFormatterCsv(ts *TimeSeries) error {
+	for _, row := range ts.table() {
+		for i := range row {
+			row[i] = client.config.QuoteChar + row[i] + client.config.QuoteChar
+		}
+		fmt.Fprint(client.out, strings.Join(row, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return nil
+}
+
+func (client *TrelloClient) timeSeriesFormatterMarkdown(ts *TimeSeries) error {
+	rows := ts.table()
+	fmt.Fprintln(client.out, "# "+ts.Title)
+	fmt.Fprintln(client.out)
+	fmt.Fprintln(client.out, "| "+strings.Join(rows[0], " | ")+" |")
+	fmt.Fprintln(client.out, "|---"+strings.Repeat("|--:", len(rows[0])-1)+"|")
+	for _, row := range rows[1:] {
+		fmt.Fprintln(client.out, "| "+strings.Join(row, " | ")+" |")
+	}
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+func (client *TrelloClient) timeSeriesFormatterJSON(ts *TimeSeries) error {
+	doc, err := json.Marshal(ts)
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+func (client *TrelloClient) timeSeriesFormatterExcel(ts *TimeSeries) (err error) {
+	file := xlsx.NewFile()
+	if err = addSheetRows(file, "Data", ts.table()); err != nil {
+		return
+	}
+	return file.Write(client.out)
+}
+
+var chartColors = []string{"#61bd4f", "#0079bf", "#f2d600", "#ff9f1a", "#c377e0", "#eb5a46", "#00c2e0", "#51e898", "#ff78cb", "#344563"}
+
+// timeSeriesFormatterSVG draws a self-contained SVG chart, stacked areas for
+// a cumulative flow diagram or lines for a burndown
+func (client *TrelloClient) timeSeriesFormatterSVG(ts *TimeSeries, stacked bool) error {
+	const (
+		width, height = 900.0, 500.0
+		left, right   = 60.0, 180.0
+		top, bottom   = 40.0, 60.0
+	)
+	plotW, plotH := width-left-right, height-top-bottom
+	n := len(ts.Rows)
+	if n == 0 {
+		return errors.New("No data for the chart")
+	}
+
+	// cumulative values for stacking
+	tops := make([][]float64, n)
+	maxY := 0.0
+	for i, row := range ts.Rows {
+		tops[i] = make([]float64, len(ts.Series))
+		sum := 0.0
+		for j, v := range row.Values {
+			if stacked {
+				sum += v
+				tops[i][j] = sum
+			} else {
+				tops[i][j] = v
+			}
+			maxY = math.Max(maxY, tops[i][j])
+		}
+	}
+	// five grid lines with whole numbers
+	maxY = math.Max(5, math.Ceil(maxY/5)*5)
+	x := func(i int) float64 {
+		if n == 1 {
+			return left
+		}
+		return left + plotW*float64(i)/float64(n-1)
+	}
+	y := func(v float64) float64 {
+		return top + plotH - plotH*v/maxY
+	}
+	pt := func(i int, v float64) string {
+		return strconv.FormatFloat(x(i), 'f', 1, 64) + "," + strconv.FormatFloat(y(v), 'f', 1, 64)
+	}
+
+	buf := []string{}
+	buf = append(buf, fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g" font-family="sans-serif" font-size="12">`, width, height, width, height))
+	buf = append(buf, `<rect width="100%" height="100%" fill="#ffffff"/>`)
+	buf = append(buf, fmt.Sprintf(`<text x="%g" y="24" font-size="16" font-weight="bold">%s</text>`, left, html.EscapeString(ts.Title)))
+
+	// grid and y axis labels
+	for k := 0; k <= 5; k++ {
+		v := maxY * float64(k) / 5
+		buf = append(buf, fmt.Sprintf(`<line x1="%g" y1="%.1f" x2="%g" y2="%.1f" stroke="#dddddd"/>`, left, y(v), left+plotW, y(v)))
+		buf = append(buf, fmt.Sprintf(`<text x="%g" y="%.1f" text-anchor="end">%s</text>`, left-6, y(v)+4, strconv.FormatFloat(v, 'f', -1, 64)))
+	}
+	// x axis labels, at most about ten
+	step := int(math.Ceil(float64(n) / 10))
+	for i := 0; i < n; i += step {
+		buf = append(buf, fmt.Sprintf(`<text x="%.1f" y="%g" text-anchor="middle">%s</text>`, x(i), top+plotH+20, ts.Rows[i].Date[5:]))
+	}
+
+	for j := len(ts.Series) - 1; j >= 0; j-- {
+		color := chartColors[j%len(chartColors)]
+		points := []string{}
+		for i := 0; i < n; i++ {
+			points = append(points, pt(i, tops[i][j]))
+		}
+		if stacked {
+			// close the area along the top of the series below
+			for i := n - 1; i >= 0; i-- {
+				below := 0.0
+				if j > 0 {
+					below = tops[i][j-1]
+				}
+				points = append(points, pt(i, below))
+			}
+			buf = append(buf, fmt.Sprintf(`<polygon points="%s" fill="%s" stroke="%s" fill-opacity="0.85"/>`, strings.Join(points, " "), color, color))
+		} else {
+			dash := ""
+			if ts.Series[j] == "ideal" {
+				dash = ` stroke-dasharray="6,4"`
+			}
+			buf = append(buf, fmt.Sprintf(`<polyline points="%s" fill="none" stroke="%s" stroke-width="2"%s/>`, strings.Join(points, " "), color, dash))
+		}
+	}
+	buf = append(buf, fmt.Sprintf(`<rect x="%g" y="%g" width="%g" height="%g" fill="none" stroke="#888888"/>`, left, top, plotW, plotH))
+
+	// legend, top entry is the top area
+	for k := 0; k < len(ts.Series); k++ {
+		j := len(ts.Series) - 1 - k
+		if !stacked {
+			j = k
+		}
+		ly := top + 10 + float64(k)*20
+		buf = append(buf, fmt.Sprintf(`<rect x="%g" y="%g" width="12" height="12" fill="%s"/>`, left+plotW+15, ly, chartColors[j%len(chartColors)]))
+		buf = append(buf, fmt.Sprintf(`<text x="%g" y="%g">%s</text>`, left+plotW+33, ly+10, html.EscapeString(ts.Series[j])))
+	}
+	buf = append(buf, `</svg>`)
+	fmt.Fprintln(client.out, strings.Join(buf, "\n"))
+	return nil
+}
+
+func (client *TrelloClient) outputTimeSeries(ts *TimeSeries, stacked bool) error {
+	var err error
+	switch strings.ToLower(client.config.Format) {
+	case "text":
+		err = client.timeSeriesFormatterText(ts)
+	case "csv":
+		err = client.timeSeriesFormatterCsv(ts)
+	case "json":
+		err = client.timeSeriesFormatterJSON(ts)
+	case "excel":
+		err = client.timeSeriesFormatterExcel(ts)
+	case "markdown":
+		err = client.timeSeriesFormatterMarkdown(ts)
+	case "svg":
+		err = client.timeSeriesFormatterSVG(ts, stacked)
+	default:
+		err = errors.New("INVALID_OUTPUT_FORMAT")
+	}
+	return err
+}
+
+// CumulativeFlow prints the number of cards per list and day, reconstructed
+// from the actions of the board.
+func (client *TrelloClient) CumulativeFlow() error {
+	boardID, boardName, err := client.boardFromArg()
+	if err != nil {
+		return err
+	}
+	ts, err := client.cumulativeFlow(boardID, boardName)
+	if err != nil {
+		return err
+	}
+	return client.outputTimeSeries(ts, true)
+}
+
+// Burndown prints the remaining and done cards (or points) per day.
+func (client *TrelloClient) Burndown() error {
+	boardID, boardName, err := client.boardFromArg()
+	if err != nil {
+		return err
+	}
+	ts, err := client.burndown(boardID, boardName)
+	if err != nil {
+		return err
+	}
+	return client.outputTimeSeries(ts, false)
+}
@@ -18,11 +18,37 @@ func init() {
 	flag.StringVar(&config.RowSep, "rowsep", "\n", "row separator for result lines")
 	flag.StringVar(&config.QuoteChar, "quotechar", "", "quote string for columns")
 	flag.StringVar(&config.SearchResultFields, "fields", "name", "list of result field names")
-	flag.StringVar(&config.Format, "format", "text", "output format (text|excel|csv|json|markdown)")
+	flag.StringVar(&config.Format, "format", "text", "output format (text|excel|csv|json|markdown|html|kanban|ical|svg)")
 	flag.IntVar(&config.CardLimit, "limit", 200, "limit of cards to retrieve")
 	flag.BoolVar(&config.NumberOutput, "number", false, "display row numbers for output lines")
-	flag.StringVar(&config.BoardName, "board", "", "")
-	flag.StringVar(&config.ListName, "list", "", "")
+	flag.StringVar(&config.BoardName, "board", "", "restrict searches to a board, board of members and cards")
+	flag.StringVar(&config.ListName, "list", "", "restrict searches and cards to a list, done list of burndown")
+	flag.StringVar(&config.LocalCards, "local", "", "evaluate search locally against a JSON card export")
+	flag.StringVar(&config.SortFields, "sort", "", "sort cards by field[:desc],...")
+	flag.StringVar(&config.GroupBy, "group-by", "", "group cards by boardname|listname|label|member|due")
+	flag.StringVar(&config.Where, "where", "", "filter expression applied to the search result")
+	flag.BoolVar(&config.Aggregate, "aggregate", false, "print statistics per group instead of cards")
+	flag.StringVar(&config.StartList, "start-list", "", "list where work on a card starts (flow)")
+	flag.StringVar(&config.EndList, "end-list", "", "list where work on a card is done (flow)")
+	flag.StringVar(&config.Since, "since", "", "start date for reports, YYYY-MM-DD or e.g. today-30d")
+	flag.StringVar(&config.From, "from", "", "first day of a time series (cfd, burndown)")
+	flag.StringVar(&config.To, "to", "", "last day of a time series (cfd, burndown)")
+	flag.StringVar(&config.Points, "points", "cards", "what to count per card, cards|checkitems|cf:<name>")
+	flag.BoolVar(&config.Overdue, "overdue", false, "only overdue cards (due)")
+	flag.StringVar(&config.Within, "within", "", "only cards due within a period like 7d (due)")
+	flag.StringVar(&config.Member, "member", "", "only cards of this member (due)")
+	flag.IntVar(&config.Days, "days", 30, "days without activity (stale)")
+	flag.BoolVar(&config.ByActions, "by-actions", false, "only comments and list moves count as activity (stale)")
+	flag.StringVar(&config.Comment, "comment", "", "add this comment to every stale card")
+	flag.StringVar(&config.Label, "label", "", "add this label to every stale card")
+	flag.StringVar(&config.Interval, "interval", "1m", "time between two polls (watch)")
+	flag.StringVar(&config.Exec, "exec", "", "command that gets every event as JSON on stdin (watch)")
+	flag.StringVar(&config.Listen, "listen", ":8080", "address the server listens on")
+	flag.StringVar(&config.PublicURL, "public-url", "", "URL Trello calls for webhooks (serve-webhooks)")
+	flag.StringVar(&config.Queries, "queries", "", "directory with the query files to serve (serve)")
+	flag.StringVar(&config.Auth, "auth", "", "user:password for HTTP basic auth (serve)")
+	flag.Var(&config.Vars, "set", "set a query file variable, name=value (repeatable)")
+	flag.StringVar(&config.CacheTime, "cache", "5m", "how long results are cached, 0 disables the cache (serve)")
 }
 
 func main() {
@@ -33,21 +59,60 @@ func main() {
 	}
 
 	var err error
-	trello := tres.NewTrelloClient(config)
 	config.Command = strings.ToLower(strings.TrimSpace(flag.Args()[0]))
+	var trello *tres.TrelloClient
+	if config.Command == "completion" || config.Command == "__complete" {
+		trello = tres.NewOfflineClient(config) // completion works from the metadata cache
+	} else {
+		trello = tres.NewTrelloClient(config)
+	}
 	type errFunc func() error
 	cmds := map[string]errFunc{
-		"search":  trello.Search,
-		"members": trello.FetchAllMembers,
-		"boards":  trello.FetchAllBoards,
+		"search":         trello.Search,
+		"members":        trello.FetchAllMembers,
+		"cards":          trello.Cards,
+		"boards":         trello.FetchAllBoards,
+		"board":          trello.Board,
+		"stats":          trello.Stats,
+		"history":        trello.History,
+		"flow":           trello.Flow,
+		"cfd":            trello.CumulativeFlow,
+		"burndown":       trello.Burndown,
+		"due":            trello.Due,
+		"stale":          trello.Stale,
+		"workload":       trello.Workload,
+		"member":         trello.Member,
+		"diff":           trello.Diff,
+		"watch":          trello.Watch,
+		"serve-webhooks": trello.ServeWebhooks,
+		"serve":          trello.Serve,
+		"ui":             trello.UI,
+		"shell":          trello.Shell,
+	}
+
+	commands := []string{}
+	for name := range cmds {
+		commands = append(commands, name)
+	}
+	commands = append(commands, "completion")
+	// these work without asking Trello for the boards first
+	offline := map[string]errFunc{
+		"completion": func() error { return trello.Completion(commands) },
+		"__complete": trello.Complete,
 	}
 
 	f, present := cmds[config.Command]
 	if present {
 		err = trello.FetchBoardInfo()
+		if err != nil && config.LocalCards != "" {
+			err = nil // offline, names are taken from the export if possible
+		}
 		if err == nil {
 			err = f()
+			trello.SaveMetadataCache() // for shell completion, a failure does not matter
 		}
+	} else if f, present = offline[config.Command]; present {
+		err = f()
 	} else {
 		err = errors.New("Unknown command " + config.Command)
 	}
@@ -68,33 +133,136 @@ Commands:
     search              'trello_search_query' | <filename>
                         see http://help.trello.com/article/808-searching-for-cards-all-boards
                         literal search query must be enclosed in single quotes
-                        filename must contain a literal query without single quotes
-    members "<name>"    retrieve members of the specified board
+                        filename must contain a literal query without single quotes,
+                        with @section blocks it writes a report of several queries
+    members "<name>"    retrieve members of the specified board (or --board)
+    cards "<board>"     all open cards of a board (or --board), of a single list
+                        with --list, read directly instead of via search
     boards              retrieve board name/id and and list name/id for each board
+    board show "<board>"
+                        the open lists of a board side by side with their cards,
+                        labels, member initials and due dates
+    stats               'trello_search_query' | <filename>
+                        count cards, checklist items, comments and overdue cards
+                        per --group-by dimension (default listname)
+    history             <card id|short link> | 'trello_search_query' | <filename>
+                        timeline of card actions and time spent in each list
+                        (text|csv|json|markdown)
+    flow "<board>"      lead time, cycle time, weekly throughput and aging work
+                        in progress, needs --start-list and --end-list
+    cfd "<board>"       cards per list and day (cumulative flow diagram),
+                        use --format svg for a chart
+    burndown "<board>"  remaining and done cards per day, needs --list with the
+                        name of the done list, use --format svg for a chart
+    due                 [ 'trello_search_query' | <filename> ]
+                        cards by due date, without a query the cards are searched
+                        with --board, --member, --overdue and --within,
+                        use --format ical for a calendar file
+    stale               [ 'trello_search_query' | <filename> ]
+                        open cards without activity for --days days, grouped
+                        by --group-by (default listname), optionally flagged
+                        with --comment or --label
+    workload "<board>"  per member open and overdue cards, unchecked checklist
+                        items and actions since --since, "all" for all boards
+    member <username>   boards of a member and the open cards assigned to it,
+                        grouped by --group-by (default boardname)
+    diff "<board>"      cards added, removed, moved, renamed, relabeled,
+                        reassigned or completed since --since, which is a date
+                        (default today-7d) or a snapshot file (tres json output)
+                        (text|markdown|json as JSON patch)
+    watch               "<board>" | 'trello_search_query' | <filename>
+                        poll every --interval and print a line per change,
+                        --format json writes one JSON object per line
+    serve-webhooks      "<board>" ["<board>" ...]
+                        register Trello webhooks for the boards that call
+                        --public-url and print the changes like watch, needs
+                        TRELLO_SECRET, the webhooks are deleted on Ctrl-C
+    serve               serve every query file <name>.trs in --queries as
+                        http://<listen>/<name>.json (or .csv, .xlsx, .md, .html,
+                        .txt), URL parameters set ${name} variables in the file
+    ui                  [ 'trello_search_query' | <filename> ]
+                        browse the cards full screen, open a card with enter,
+                        move (m), label (l), comment (c) or archive (a) it
+    shell               interactive prompt for search queries and at-commands
+                        with history and tab completion, :help lists the
+                        shell commands, :save writes the session as query file
+    completion          bash|zsh|fish
+                        print the shell completion script, e.g. for bash
+                        source <(tres completion bash)
+    completion refresh  cache the label and member names of all boards for
+                        the completion
 
 Options:
     --colsep <string>   set column separator for result columns
     --rowsep <string>   set row separator for result lines
     --fields <string>   a comma-separated list of result field names for a search
-    --format <string>   specify output format (one of: text|excel|csv|json|markdown)
+    --format <string>   specify output format (one of: text|excel|csv|json|markdown|
+                        html|kanban|ical|svg)
     --limit <n>         limit number of resulting cards (default 200)
+    --board <name>      restrict search, stats, due, stale, ... to a board and
+                        the board of members, cards and the board commands
+    --list <name>       restrict search, stats, ... and cards to a list; the done
+                        list of burndown
+    --local <file>      evaluate the search query locally against cards from a
+                        JSON file (tres json output or a Trello board export)
+    --sort <fields>     sort cards by a comma-separated list of field names,
+                        append :desc to a field to sort descending
+    --group-by <field>  group cards by one of boardname|listname|label|member|due
+    --where <expr>      filter the cards after the search, e.g.
+                        'commentcount > 3 and due before today+7d'
+    --aggregate         search prints statistics like the stats command
+    --set <name=value>  set the variable ${name} of a query file, can be given
+                        more than once
+    --start-list <name> list where the work on a card starts (flow)
+    --end-list <name>   list where the work on a card is done (flow)
+    --since <date>      start of the reporting period for flow, workload and diff,
+                        YYYY-MM-DD or relative like today-30d (default 90 days ago)
+    --from <date>       first day of cfd and burndown (default today-30d)
+    --to <date>         last day of cfd and burndown (default today)
+    --points <what>     count cards (default), checkitems or a number custom
+                        field cf:<name> in cfd and burndown
+    --overdue           due lists only overdue cards
+    --within <period>   due lists only cards due within e.g. 7d, 2w or 12h,
+                        together with --overdue both are listed
+    --member <name>     due lists only cards of this member
+    --days <n>          stale lists cards without activity for n days (default 30)
+    --by-actions        stale only counts comments and list moves as activity,
+                        needs one request per card
+    --comment <text>    stale adds this comment to every stale card
+    --label <name>      stale adds this label to every stale card, the label is
+                        created if the board does not have it
+    --interval <time>   watch polls Trello every 30s, 5m, ... (default 1m)
+    --exec <command>    watch runs the shell command for every change with the
+                        change as JSON on stdin (watch, serve-webhooks)
+    --listen <addr>     address the server listens on (default :8080)
+    --public-url <url>  URL of this server Trello calls for webhooks, e.g.
+                        https://example.com/trello, must reach --listen
+    --queries <dir>     directory with the query files for serve
+    --auth <user:pwd>   serve requires HTTP basic auth with this user and
+                        password (default $TRES_AUTH)
+    --cache <time>      serve caches results for 30s, 5m, ... (default 5m),
+                        0 disables the cache
 
 List of field names:
-    attachmentcount     hasdesc             labelcolors
-    boardname           id                  labels
-    checked             idattachmentcover   listname
-    closed              idboard             name
-    commentcount        idchecklists        pos
-    comments            idlabels            shortlink
-    datelastactivity    idlist              shorturl
-    desc                idmembers           subscribed
-    due                 idmembersvoted      url
-    email               idshort
+    attachmentcount     created             idboard             memberinitials
+    attachmentnames     datelastactivity    idchecklists        members
+    boardname           desc                idlabels            name
+    checked             due                 idlist              overdue
+    checkedratio        duecomplete         idmembers           pos
+    checkitems          duedate             idmembersvoted      shortlink
+    checkitemschecked   duerelative         idshort             shorturl
+    checklistnames      email               inactivedays        subscribed
+    closed              hasdesc             labelcolors         url
+    commentcount        id                  labels              voters
+    comments            idattachmentcover   listname            votes
+    cf:<name>           value of the custom field <name>
 
 Environment vars used:
     TRELLO_KEY          your Trello API key
     TRELLO_TOKEN        your Trello API token
     TRELLO_USER         optional (defaults to "me"), you Trello API user name
+    TRELLO_SECRET       your Trello API secret, only for serve-webhooks
+    TRES_AUTH           optional user:password for serve if --auth is not given
 
 If anything goes wrong, the tool exits with a return code of 1.
 
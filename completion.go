@@ -0,0 +1,441 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the fields of --fields and --sort, see fieldValue
+var cardFields = []string{
+	"attachmentcount", "attachmentnames", "boardname", "checked", "checkedratio", "checkitems",
+	"checkitemschecked", "checklistnames", "closed", "commentcount", "comments", "created",
+	"datelastactivity", "desc", "due", "duecomplete", "duedate", "duerelative", "email", "hasdesc",
+	"id", "idattachmentcover", "idboard", "idchecklists", "idlabels", "idlist", "idmembers",
+	"idmembersvoted", "idshort", "inactivedays", "labelcolors", "labels", "listname",
+	"memberinitials", "members", "name", "overdue", "pos", "shortlink", "shorturl", "subscribed",
+	"url", "voters", "votes",
+}
+
+// commands whose argument is a board name or a search query
+var (
+	boardCommands = []string{"members", "cards", "flow", "cfd", "burndown", "workload", "diff", "serve-webhooks"}
+	queryCommands = []string{"search", "stats", "history", "due", "stale", "watch", "ui"}
+)
+
+// metadataCache keeps the names of boards, lists, labels and members
+// between runs, so shell completion works without asking Trello
+type metadataCache struct {
+	Updated string         `json:"updated"`
+	Boards  []*cachedBoard `json:"boards"`
+}
+
+type cachedBoard struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Lists   []string `json:"lists"`
+	Labels  []string `json:"labels,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
+func metadataCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tres", "metadata.json"), nil
+}
+
+func readMetadataCache() (*metadataCache, error) {
+	filename, err := metadataCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cache := &metadataCache{}
+	err = json.Unmarshal(data, cache)
+	return cache, err
+}
+
+// SaveMetadataCache writes the names of the boards and their lists to the
+// cache for shell completion, with the labels and members this run has
+// read. Labels and members of other boards are kept from the old cache.
+func (client *TrelloClient) SaveMetadataCache() error {
+	if len(client.TrelloBoards) == 0 {
+		return nil
+	}
+	old := map[string]*cachedBoard{}
+	if cache, err := readMetadataCache(); err == nil {
+		for _, board := range cache.Boards {
+			old[board.ID] = board
+		}
+	}
+	cache := &metadataCache{Updated: client.Now().UTC().Format("2006-01-02T15:04:05Z")}
+	for _, board := range client.TrelloBoards {
+		cached := &cachedBoard{ID: board.ID, Name: board.Name, Lists: []string{}}
+		for _, list := range client.TrelloLists[strings.ToLower(board.Name)] {
+			cached.Lists = append(cached.Lists, list.Name)
+		}
+		if dir, ok := client.directories[board.ID]; ok && dir.loaded["labels"] {
+			for _, label := range dir.labels {
+				if label.Name != "" {
+					cached.Labels = append(cached.Labels, label.Name)
+				}
+			}
+		} else if o, ok := old[board.ID]; ok {
+			cached.Labels = o.Labels
+		}
+		if dir, ok := client.directories[board.ID]; ok && dir.loaded["members"] {
+			for _, member := range dir.members {
+				cached.Members = append(cached.Members, member.UserName)
+			}
+		} else if o, ok := old[board.ID]; ok {
+			cached.Members = o.Members
+		}
+		cache.Boards = append(cache.Boards, cached)
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename, err := metadataCacheFile()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0600)
+}
+
+// loadMetadataCache fills boards, lists and the board directories from the
+// cache instead of Trello
+func (client *TrelloClient) loadMetadataCache() error {
+	cache, err := readMetadataCache()
+	if err != nil {
+		return err
+	}
+	for _, board := range cache.Boards {
+		client.TrelloBoards = append(client.TrelloBoards, &TrelloName{ID: board.ID, Name: board.Name})
+		lists := TrelloNameList{}
+		for _, name := range board.Lists {
+			lists = append(lists, &TrelloName{Name: name})
+		}
+		client.TrelloLists[strings.ToLower(board.Name)] = lists
+		dir := client.boardDirectory(board.ID)
+		for _, name := range board.Labels {
+			dir.labels = append(dir.labels, &TrelloLabel{Name: name, IDBoard: board.ID})
+		}
+		for _, name := range board.Members {
+			dir.members = append(dir.members, &TrelloMember{UserName: name})
+		}
+		dir.loaded["labels"], dir.loaded["members"] = true, true
+	}
+	return nil
+}
+
+// matching returns the names that start with prefix, ignoring case
+func matching(names []string, prefix string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if !seen[name] && strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// queryCompletions completes the search query at the end of line. The
+// candidates replace the current shell word, which may be only the part
+// of the query word after a colon (bash) or start with the quote (zsh).
+func (client *TrelloClient) queryCompletions(line, word string) []string {
+	if strings.Count(line, "'")%2 == 1 {
+		line = line[strings.LastIndex(line, "'")+1:]
+	}
+	sh := &shell{client: client}
+	start, candidates := sh.complete(line)
+	token := line[start:]
+	result := []string{}
+	for _, c := range candidates {
+		switch {
+		case strings.HasSuffix(token, word) && len(c) >= len(token)-len(word):
+			c = c[len(token)-len(word):]
+		case strings.HasSuffix(word, token):
+			c = word[:len(word)-len(token)] + c
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// Complete is the hidden __complete command the completion scripts call:
+// tres __complete boards|lists|fields|query <word> [<line>]. It prints
+// one candidate per line from the metadata cache, Trello is not asked.
+func (client *TrelloClient) Complete() error {
+	if flag.NArg() < 2 {
+		return errors.New("Usage: tres __complete boards|lists|fields|query <word> [<line>]")
+	}
+	word := flag.Arg(2)
+	client.loadMetadataCache() // no cache, no names
+	names := []string{}
+	switch flag.Arg(1) {
+	case "boards":
+		for _, board := range client.TrelloBoards {
+			names = append(names, board.Name)
+		}
+		names = matching(names, word)
+	case "lists":
+		for _, lists := range client.TrelloLists {
+			for _, list := range lists {
+				names = append(names, list.Name)
+			}
+		}
+		names = matching(names, word)
+	case "fields":
+		// the last of a comma separated list
+		i := strings.LastIndex(word, ",") + 1
+		for _, field := range matching(cardFields, word[i:]) {
+			names = append(names, word[:i]+field)
+		}
+	case "query":
+		line := word
+		if flag.NArg() >= 4 {
+			line = flag.Arg(3)
+		}
+		names = client.queryCompletions(line, word)
+	}
+	for _, name := range names {
+		fmt.Fprintln(client.out, name)
+	}
+	return nil
+}
+
+// completionFlags returns the flags that take a value and the bool flags
+func completionFlags() ([]string, []string) {
+	valueFlags, boolFlags := []string{}, []string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			boolFlags = append(boolFlags, f.Name)
+		} else {
+			valueFlags = append(valueFlags, f.Name)
+		}
+	})
+	return valueFlags, boolFlags
+}
+
+func prefixed(prefix string, names []string) []string {
+	result := []string{}
+	for _, name := range names {
+		result = append(result, prefix+name)
+	}
+	return result
+}
+
+// flags with dynamic completion, the other value flags complete files
+var (
+	boardFlags = []string{"board"}
+	listFlags  = []string{"list", "start-list", "end-list"}
+	fieldFlags = []string{"fields", "sort"}
+)
+
+func otherValueFlags(valueFlags []string) []string {
+	result := []string{}
+	for _, name := range valueFlags {
+		switch name {
+		case "board", "list", "start-list", "end-list", "fields", "sort", "format", "group-by":
+		default:
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func bashCompletion(commands, valueFlags, boolFlags []string) string {
+	all := append(prefixed("--", valueFlags), prefixed("--", boolFlags)...)
+	sort.Strings(all)
+	return `# bash completion for tres, generated by "tres completion bash"
+# source it from ~/.bashrc: source <(tres completion bash)
+
+_tres_dynamic() {
+    local line
+    COMPREPLY=()
+    while IFS= read -r line; do
+        case "$1" in
+            boards|lists) COMPREPLY+=("$(printf '%q' "$line")") ;;
+            *) COMPREPLY+=("$line") ;;
+        esac
+    done < <(tres __complete "$1" "$2" "${COMP_LINE:0:COMP_POINT}" 2>/dev/null)
+}
+
+_tres() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        --format|-format) COMPREPLY=($(compgen -W "` + strings.Join(outputFormats, " ") + ` svg" -- "$cur")); return ;;
+        --group-by|-group-by) COMPREPLY=($(compgen -W "` + strings.Join(groupDimensions, " ") + `" -- "$cur")); return ;;
+        ` + strings.Join(append(prefixed("--", boardFlags), prefixed("-", boardFlags)...), "|") + `) _tres_dynamic boards "$cur"; return ;;
+        ` + strings.Join(append(prefixed("--", listFlags), prefixed("-", listFlags)...), "|") + `) _tres_dynamic lists "$cur"; return ;;
+        ` + strings.Join(append(prefixed("--", fieldFlags), prefixed("-", fieldFlags)...), "|") + `) _tres_dynamic fields "$cur"; return ;;
+        ` + strings.Join(prefixed("--", otherValueFlags(valueFlags)), "|") + `) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "` + strings.Join(all, " ") + `" -- "$cur"))
+        return
+    fi
+    # the command is the first word that is neither an option nor its value
+    local i cmd="" arg=0
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            ` + strings.Join(prefixed("--", boolFlags), "|") + `|-*=*) ;;
+            -*) ((i++)) ;;
+            *) if [[ -z "$cmd" ]]; then cmd="${COMP_WORDS[i]}"; else ((arg++)); fi ;;
+        esac
+    done
+    case "$cmd" in
+        "") COMPREPLY=($(compgen -W "` + strings.Join(commands, " ") + `" -- "$cur")) ;;
+        ` + strings.Join(boardCommands, "|") + `) _tres_dynamic boards "$cur" ;;
+        ` + strings.Join(queryCommands, "|") + `) _tres_dynamic query "$cur"
+            [[ ${#COMPREPLY[@]} -eq 0 ]] && COMPREPLY=($(compgen -f -- "$cur")) ;;
+        board) if ((arg == 0)); then COMPREPLY=($(compgen -W "show" -- "$cur")); else _tres_dynamic boards "$cur"; fi ;;
+        completion) COMPREPLY=($(compgen -W "bash zsh fish refresh" -- "$cur")) ;;
+        *) COMPREPLY=($(compgen -f -- "$cur")) ;;
+    esac
+}
+
+complete -F _tres tres
+`
+}
+
+func zshCompletion(commands, valueFlags, boolFlags []string) string {
+	all := append(prefixed("--", valueFlags), prefixed("--", boolFlags)...)
+	sort.Strings(all)
+	return `#compdef tres
+# zsh completion for tres, generated by "tres completion zsh"
+# put it into a directory of $fpath as _tres, or: source <(tres completion zsh)
+
+_tres_dynamic() {
+    local -a names
+    names=("${(@f)$(tres __complete "$1" "$2" "$LBUFFER" 2>/dev/null)}")
+    names=(${names:#})
+    if [[ $1 == query ]]; then
+        compadd -U -Q -- $names
+    else
+        compadd -- $names
+    fi
+}
+
+_tres() {
+    local cur=${words[CURRENT]} prev=${words[CURRENT-1]}
+    case $prev in
+        --format|-format) compadd -- ` + strings.Join(outputFormats, " ") + ` svg; return ;;
+        --group-by|-group-by) compadd -- ` + strings.Join(groupDimensions, " ") + `; return ;;
+        ` + strings.Join(append(prefixed("--", boardFlags), prefixed("-", boardFlags)...), "|") + `) _tres_dynamic boards "$cur"; return ;;
+        ` + strings.Join(append(prefixed("--", listFlags), prefixed("-", listFlags)...), "|") + `) _tres_dynamic lists "$cur"; return ;;
+        ` + strings.Join(append(prefixed("--", fieldFlags), prefixed("-", fieldFlags)...), "|") + `) _tres_dynamic fields "$cur"; return ;;
+        ` + strings.Join(prefixed("--", otherValueFlags(valueFlags)), "|") + `) _files; return ;;
+    esac
+    if [[ $cur == -* ]]; then
+        compadd -- ` + strings.Join(all, " ") + `
+        return
+    fi
+    local i cmd="" arg=0
+    for ((i = 2; i < CURRENT; i++)); do
+        case ${words[i]} in
+            ` + strings.Join(prefixed("--", boolFlags), "|") + `|-*=*) ;;
+            -*) ((i++)) ;;
+            *) if [[ -z $cmd ]]; then cmd=${words[i]}; else ((arg++)); fi ;;
+        esac
+    done
+    case $cmd in
+        "") compadd -- ` + strings.Join(commands, " ") + ` ;;
+        ` + strings.Join(boardCommands, "|") + `) _tres_dynamic boards "$cur" ;;
+        ` + strings.Join(queryCommands, "|") + `) _tres_dynamic query "$cur"; _files ;;
+        board) if ((arg == 0)); then compadd -- show; else _tres_dynamic boards "$cur"; fi ;;
+        completion) compadd -- bash zsh fish refresh ;;
+        *) _files ;;
+    esac
+}
+
+compdef _tres tres
+`
+}
+
+func fishCompletion(commands, valueFlags, boolFlags []string) string {
+	s := `# fish completion for tres, generated by "tres completion fish"
+# save it as ~/.config/fish/completions/tres.fish
+
+complete -c tres -f
+complete -c tres -n __fish_use_subcommand -a "` + strings.Join(commands, " ") + `"
+complete -c tres -n "__fish_seen_subcommand_from ` + strings.Join(boardCommands, " ") + `" -a "(tres __complete boards (commandline -ct))"
+complete -c tres -n "__fish_seen_subcommand_from ` + strings.Join(queryCommands, " ") + `" -a "(tres __complete query (commandline -ct) (commandline -cp))"
+complete -c tres -n "__fish_seen_subcommand_from board; and not __fish_seen_subcommand_from show" -a show
+complete -c tres -n "__fish_seen_subcommand_from show" -a "(tres __complete boards (commandline -ct))"
+complete -c tres -n "__fish_seen_subcommand_from completion" -a "bash zsh fish refresh"
+complete -c tres -l format -x -a "` + strings.Join(outputFormats, " ") + ` svg"
+complete -c tres -l group-by -x -a "` + strings.Join(groupDimensions, " ") + `"
+`
+	for _, name := range boardFlags {
+		s += "complete -c tres -l " + name + ` -x -a "(tres __complete boards (commandline -ct))"` + "\n"
+	}
+	for _, name := range listFlags {
+		s += "complete -c tres -l " + name + ` -x -a "(tres __complete lists (commandline -ct))"` + "\n"
+	}
+	for _, name := range fieldFlags {
+		s += "complete -c tres -l " + name + ` -x -a "(tres __complete fields (commandline -ct))"` + "\n"
+	}
+	for _, name := range otherValueFlags(valueFlags) {
+		s += "complete -c tres -l " + name + " -r -F\n"
+	}
+	for _, name := range boolFlags {
+		s += "complete -c tres -l " + name + "\n"
+	}
+	return s
+}
+
+// Completion prints the completion script for bash, zsh or fish, or with
+// "refresh" reads the labels and members of all boards into the metadata
+// cache. Commands is the list of commands to complete.
+func (client *TrelloClient) Completion(commands []string) error {
+	if flag.NArg() < 2 {
+		return errors.New("Missing shell, use: tres completion bash|zsh|fish|refresh")
+	}
+	sort.Strings(commands)
+	valueFlags, boolFlags := completionFlags()
+	switch strings.ToLower(flag.Arg(1)) {
+	case "bash":
+		fmt.Fprint(client.out, bashCompletion(commands, valueFlags, boolFlags))
+	case "zsh":
+		fmt.Fprint(client.out, zshCompletion(commands, valueFlags, boolFlags))
+	case "fish":
+		fmt.Fprint(client.out, fishCompletion(commands, valueFlags, boolFlags))
+	case "refresh":
+		if client.TrelloKey == "" || client.TrelloToken == "" {
+			return errors.New("TRELLO_KEY and TRELLO_TOKEN must be set to read the boards")
+		}
+		if err := client.FetchBoardInfo(); err != nil {
+			return err
+		}
+		for _, board := range client.TrelloBoards {
+			client.directoryLabels(board.ID)
+			client.directoryMembers(board.ID)
+		}
+		if err := client.SaveMetadataCache(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Cached the names of "+plural(len(client.TrelloBoards), "board"))
+	default:
+		return errors.New("Unknown shell " + flag.Arg(1) + ", use: tres completion bash|zsh|fish|refresh")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package tres
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type TrelloCustomField struct {
+	ID      string  `json:"id"`
+	IDModel string  `json:"idModel"`
+	Name    string  `json:"name"`
+	Pos     float64 `json:"pos"`
+	Type    string  `json:"type"` // number, date, checkbox, list or text
+	Options []struct {
+		ID    string  `json:"id"`
+		Color string  `json:"color"`
+		Pos   float64 `json:"pos"`
+		Value struct {
+			Text string `json:"text"`
+		} `json:"value"`
+	} `json:"options"`
+}
+
+type TrelloCustomFieldItem struct {
+	ID            string            `json:"id"`
+	IDCustomField string            `json:"idCustomField"`
+	IDModel       string            `json:"idModel"`
+	IDValue       string            `json:"idValue"`
+	Value         map[string]string `json:"value"`
+}
+
+// custom fields are addressed as cf:<name> in --fields, --sort and --where
+const customFieldPrefix = "cf:"
+
+func (client *TrelloClient) BoardCustomFields(boardID string) ([]*TrelloCustomField, error) {
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/customFields", map[string]string{})
+	result := []*TrelloCustomField{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// BoardCustomFieldItems fetches the custom field values of all cards of a
+// board, the search API does not return them.
+func (client *TrelloClient) BoardCustomFieldItems(boardID string) ([]*TrelloCardSearchResult, error) {
+	q := map[string]string{
+		"fields":           "id",
+		"filter":           "all",
+		"customFieldItems": "true",
+	}
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/cards", q)
+	result := []*TrelloCardSearchResult{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+func (client *TrelloClient) directoryCustomFields(boardID string) []*TrelloCustomField {
+	dir := client.boardDirectory(boardID)
+	if !dir.loaded["customfields"] {
+		dir.customFields, _ = client.BoardCustomFields(boardID)
+		dir.loaded["customfields"] = true
+	}
+	return dir.customFields
+}
+
+func (client *TrelloClient) cardCustomFieldItems(card *TrelloCardSearchResult) []*TrelloCustomFieldItem {
+	if card.CustomFieldItems != nil {
+		return card.CustomFieldItems
+	}
+	dir := client.boardDirectory(card.IDBoard)
+	if !dir.loaded["customfielditems"] {
+		dir.customFieldItems = make(map[string][]*TrelloCustomFieldItem)
+		cards, _ := client.BoardCustomFieldItems(card.IDBoard)
+		for _, v := range cards {
+			dir.customFieldItems[v.ID] = v.CustomFieldItems
+		}
+		dir.loaded["customfielditems"] = true
+	}
+	card.CustomFieldItems = dir.customFieldItems[card.ID]
+	if card.CustomFieldItems == nil {
+		card.CustomFieldItems = []*TrelloCustomFieldItem{}
+	}
+	return card.CustomFieldItems
+}
+
+func isCustomField(field string) bool {
+	return strings.HasPrefix(field, customFieldPrefix)
+}
+
+func customFieldName(field string) string {
+	return strings.Trim(strings.TrimSpace(field[len(customFieldPrefix):]), `"`)
+}
+
+func (client *TrelloClient) customFieldDefinition(boardID, name string) *TrelloCustomField {
+	for _, def := range client.directoryCustomFields(boardID) {
+		if strings.EqualFold(def.Name, name) {
+			return def
+		}
+	}
+	return nil
+}
+
+func customFieldKind(def *TrelloCustomField) fieldKind {
+	switch def.Type {
+	case "number":
+		return kindNumber
+	case "date":
+		return kindDate
+	case "checkbox":
+		return kindBool
+	}
+	return kindString
+}
+
+// customFieldKindOf determines the type of a custom field from the first
+// of the boards that defines it
+func (client *TrelloClient) customFieldKindOf(name string, boardIDs []string) (fieldKind, error) {
+	for _, boardID := range boardIDs {
+		if def := client.customFieldDefinition(boardID, name); def != nil {
+			return customFieldKind(def), nil
+		}
+	}
+	return kindString, errors.New("unknown custom field '" + name + "'")
+}
+
+// customFieldValue returns the value of a custom field as text and typed
+func (client *TrelloClient) customFieldValue(card *TrelloCardSearchResult, name string) (string, fieldVal) {
+	def := client.customFieldDefinition(card.IDBoard, name)
+	if def == nil {
+		return "", fieldVal{missing: true}
+	}
+	val := fieldVal{kind: customFieldKind(def), missing: true}
+	for _, item := range client.cardCustomFieldItems(card) {
+		if item.IDCustomField != def.ID {
+			continue
+		}
+		switch def.Type {
+		case "number":
+			n, err := strconv.ParseFloat(item.Value["number"], 64)
+			val.num, val.missing = n, err != nil
+			return item.Value["number"], val
+		case "date":
+			var ok bool
+			val.date, ok = parseTrelloDate(item.Value["date"])
+			val.missing = !ok
+			return item.Value["date"], val
+		case "checkbox":
+			val.boolean, val.missing = item.Value["checked"] == "true", false
+			return strconv.FormatBool(val.boolean), val
+		case "list":
+			for _, option := range def.Options {
+				if option.ID == item.IDValue {
+					val.str, val.missing = option.Value.Text, false
+				}
+			}
+			return val.str, val
+		default:
+			val.str, val.missing = item.Value["text"], false
+			return val.str, val
+		}
+	}
+	if val.kind == kindBool {
+		// an unchecked checkbox has no item at all
+		return "false", fieldVal{kind: kindBool}
+	}
+	return "", val
+}
+
+// resolveCustomFields fills in the custom field values of the cards by name
+// so they are part of the JSON output
+func (client *TrelloClient) resolveCustomFields(cards []*TrelloCardSearchResult) {
+	for _, card := range cards {
+		card.CustomFields = make(map[string]interface{})
+		for _, def := range client.directoryCustomFields(card.IDBoard) {
+			text, val := client.customFieldValue(card, def.Name)
+			if val.missing {
+				continue
+			}
+			switch val.kind {
+			case kindNumber:
+				card.CustomFields[def.Name] = val.num
+			case kindBool:
+				card.CustomFields[def.Name] = val.boolean
+			default:
+				card.CustomFields[def.Name] = text
+			}
+		}
+	}
+}
+
+func (client *TrelloClient) usesCustomFields() bool {
+	settings := []string{client.config.SearchResultFields, client.config.SortFields, client.config.Where}
+	for _, v := range settings {
+		if strings.Contains(strings.ToLower(v), customFieldPrefix) {
+			return true
+		}
+	}
+	return false
+}
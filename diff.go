@@ -0,0 +1,578 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// actions that change what diff compares
+const diffActionFilter = "createCard,copyCard,emailCard,convertToCardFromCheckItem,moveCardToBoard," +
+	"deleteCard,moveCardFromBoard,updateCard,addLabelToCard,removeLabelFromCard,addMemberToCard,removeMemberFromCard"
+
+// cardSnapshot is the part of a card that diff compares. It is also the
+// value of a card in the JSON patch document /cards/<id>.
+type cardSnapshot struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	ShortLink   string   `json:"shortLink,omitempty"`
+	IDList      string   `json:"idList"`
+	Closed      bool     `json:"closed"`
+	Due         string   `json:"due"`
+	DueComplete bool     `json:"dueComplete"`
+	IDLabels    []string `json:"idLabels"`
+	IDMembers   []string `json:"idMembers"`
+}
+
+// CardChange is one change of a card between two points in time. From and
+// To are list names, card names, labels or members depending on Change.
+type CardChange struct {
+	Change    string `json:"change"`
+	IDCard    string `json:"idCard"`
+	Card      string `json:"card"`
+	ShortLink string `json:"shortLink,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+}
+
+// the order of the sections in text and markdown
+var changeKinds = []string{"added", "removed", "archived", "restored", "moved", "renamed",
+	"rescheduled", "labeled", "unlabeled", "assigned", "unassigned", "completed", "reopened", "commented"}
+
+// BoardDiff is the result of the diff command
+type BoardDiff struct {
+	Board   string
+	Since   string
+	Before  map[string]*cardSnapshot
+	After   map[string]*cardSnapshot
+	Changes []*CardChange
+
+	lists   map[string]string
+	labels  map[string]string
+	members map[string]string
+}
+
+func newCardSnapshot(card *TrelloCardSearchResult) *cardSnapshot {
+	s := &cardSnapshot{
+		ID:          card.ID,
+		Name:        card.Name,
+		ShortLink:   card.ShortLink,
+		IDList:      card.IDList,
+		Closed:      card.Closed,
+		Due:         card.Due,
+		DueComplete: card.DueComplete,
+		IDLabels:    append([]string{}, card.IDLabels...),
+		IDMembers:   append([]string{}, card.IDMembers...),
+	}
+	if len(s.IDLabels) == 0 {
+		for _, label := range card.Labels {
+			s.IDLabels = append(s.IDLabels, label.ID)
+		}
+	}
+	return s
+}
+
+func (s *cardSnapshot) copy() *cardSnapshot {
+	c := *s
+	c.IDLabels = append([]string{}, s.IDLabels...)
+	c.IDMembers = append([]string{}, s.IDMembers...)
+	return &c
+}
+
+func removeID(ids []string, id string) []string {
+	result := []string{}
+	for _, v := range ids {
+		if v != id {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func addID(ids []string, id string) []string {
+	return append(removeID(ids, id), id)
+}
+
+// setDiff returns the IDs only in a and the IDs only in b
+func setDiff(a, b []string) ([]string, []string) {
+	onlyA, onlyB := []string{}, []string{}
+	for _, id := range a {
+		if len(removeID(b, id)) == len(b) {
+			onlyA = append(onlyA, id)
+		}
+	}
+	for _, id := range b {
+		if len(removeID(a, id)) == len(a) {
+			onlyB = append(onlyB, id)
+		}
+	}
+	return onlyA, onlyB
+}
+
+// undoActions turns the current state into the state before the actions by
+// undoing them newest first. actions must be sorted oldest first.
+func undoActions(state map[string]*cardSnapshot, actions []*TrelloAction, d *BoardDiff) {
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		data := &action.Data
+		id := data.Card.IDCard
+		if id == "" {
+			continue
+		}
+		if data.List.IDList != "" && d.lists[data.List.IDList] == "" {
+			d.lists[data.List.IDList] = data.List.ListName
+		}
+		for _, list := range []TrelloActionList{data.ListBefore, data.ListAfter} {
+			if list.IDList != "" && d.lists[list.IDList] == "" {
+				d.lists[list.IDList] = list.ListName
+			}
+		}
+		s := state[id]
+		switch action.Type {
+		case "createCard", "copyCard", "emailCard", "convertToCardFromCheckItem", "moveCardToBoard":
+			delete(state, id)
+			continue
+		case "deleteCard", "moveCardFromBoard":
+			s = &cardSnapshot{ID: id, Name: data.Card.CardName, ShortLink: data.Card.ShortLink, IDList: data.List.IDList}
+			if s.IDList == "" {
+				s.IDList = data.Card.IDList
+			}
+			if s.Name == "" {
+				s.Name = "#" + strconv.FormatInt(data.Card.IDShort, 10)
+			}
+			state[id] = s
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		switch action.Type {
+		case "updateCard":
+			if _, ok := data.Old["idList"]; ok && data.ListBefore.IDList != "" {
+				s.IDList = data.ListBefore.IDList
+			}
+			if v, ok := data.Old["name"].(string); ok {
+				s.Name = v
+			}
+			if v, ok := data.Old["closed"].(bool); ok {
+				s.Closed = v
+			}
+			if v, ok := data.Old["due"]; ok {
+				s.Due, _ = v.(string) // null if there was no due date
+			}
+			if v, ok := data.Old["dueComplete"].(bool); ok {
+				s.DueComplete = v
+			}
+		case "addLabelToCard":
+			s.IDLabels = removeID(s.IDLabels, data.Label.IDLabel)
+		case "removeLabelFromCard":
+			s.IDLabels = addID(s.IDLabels, data.Label.IDLabel)
+		case "addMemberToCard":
+			s.IDMembers = removeID(s.IDMembers, data.IDMember)
+		case "removeMemberFromCard":
+			s.IDMembers = addID(s.IDMembers, data.IDMember)
+		}
+		if data.Label.IDLabel != "" && d.labels[data.Label.IDLabel] == "" {
+			d.labels[data.Label.IDLabel] = labelDisplayName(data.Label.Name, data.Label.Color)
+		}
+		if action.Member != nil && data.IDMember != "" {
+			d.members[data.IDMember] = action.Member.UserName
+		}
+	}
+}
+
+func labelDisplayName(name, color string) string {
+	if name == "" {
+		return "[" + strings.ToUpper(color) + "]"
+	}
+	return name
+}
+
+func (d *BoardDiff) name(names map[string]string, id string) string {
+	if s, ok := names[id]; ok && s != "" {
+		return s
+	}
+	return id
+}
+
+// compare fills Changes from Before and After, sorted by card name
+func (d *BoardDiff) compare() {
+	ids := []string{}
+	for id := range d.Before {
+		ids = append(ids, id)
+	}
+	for id := range d.After {
+		if _, ok := d.Before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	cardName := func(id string) string {
+		if s, ok := d.After[id]; ok {
+			return s.Name
+		}
+		return d.Before[id].Name
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return strings.ToLower(cardName(ids[i])) < strings.ToLower(cardName(ids[j]))
+	})
+
+	for _, id := range ids {
+		b, a := d.Before[id], d.After[id]
+		card := a
+		if card == nil {
+			card = b
+		}
+		add := func(change, from, to string) {
+			d.Changes = append(d.Changes, &CardChange{change, id, card.Name, card.ShortLink, from, to})
+		}
+		switch {
+		case b == nil && a.Closed, b != nil && b.Closed && (a == nil || a.Closed):
+			continue // added and archived or archived all the time
+		case b == nil:
+			add("added", "", d.name(d.lists, a.IDList))
+			continue
+		case a == nil:
+			add("removed", d.name(d.lists, b.IDList), "")
+			continue
+		case a.Closed:
+			add("archived", d.name(d.lists, b.IDList), "")
+			continue
+		case b.Closed:
+			add("restored", "", d.name(d.lists, a.IDList))
+		}
+		if b.IDList != a.IDList {
+			add("moved", d.name(d.lists, b.IDList), d.name(d.lists, a.IDList))
+		}
+		if b.Name != a.Name {
+			add("renamed", b.Name, a.Name)
+		}
+		if b.Due != a.Due {
+			add("rescheduled", formatDue(b.Due), formatDue(a.Due))
+		}
+		removed, added := setDiff(b.IDLabels, a.IDLabels)
+		for _, label := range added {
+			add("labeled", "", d.name(d.labels, label))
+		}
+		for _, label := range removed {
+			add("unlabeled", d.name(d.labels, label), "")
+		}
+		removed, added = setDiff(b.IDMembers, a.IDMembers)
+		for _, member := range added {
+			add("assigned", "", "@"+d.name(d.members, member))
+		}
+		for _, member := range removed {
+			add("unassigned", "@"+d.name(d.members, member), "")
+		}
+		if !b.DueComplete && a.DueComplete {
+			add("completed", "", "")
+		} else if b.DueComplete && !a.DueComplete {
+			add("reopened", "", "")
+		}
+	}
+}
+
+// boardDiff loads the state of the board now and at --since, which is a
+// date or a snapshot file written by tres --format json or a board export
+func (client *TrelloClient) boardDiff(boardID, boardName string) (*BoardDiff, error) {
+	d := &BoardDiff{
+		Board:   boardName,
+		Before:  map[string]*cardSnapshot{},
+		After:   map[string]*cardSnapshot{},
+		lists:   map[string]string{},
+		labels:  map[string]string{},
+		members: map[string]string{},
+	}
+	since := strings.TrimSpace(client.config.Since)
+	if since == "" {
+		since = "today-7d"
+	}
+
+	var snapshot []*TrelloCardSearchResult
+	var err error
+	if isFile(since) {
+		if snapshot, err = client.LoadLocalCards(since); err != nil {
+			return nil, errors.New("Could not load snapshot " + since + ": " + err.Error())
+		}
+	}
+	cards, err := client.BoardCards(boardID, "all")
+	if err != nil {
+		return nil, err
+	}
+	lists, err := client.BoardLists(boardID, "all")
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		d.lists[list.IDList] = list.ListName
+	}
+	for _, label := range client.directoryLabels(boardID) {
+		d.labels[label.ID] = labelDisplayName(label.Name, label.Color)
+	}
+	for _, member := range client.directoryMembers(boardID) {
+		d.members[member.IDMember] = member.UserName
+	}
+	for _, card := range cards {
+		d.After[card.ID] = newCardSnapshot(card)
+	}
+
+	if snapshot != nil {
+		d.Since = since
+		for _, card := range snapshot {
+			if card.IDBoard != "" && card.IDBoard != boardID {
+				continue
+			}
+			d.Before[card.ID] = newCardSnapshot(card)
+			for _, label := range card.Labels {
+				if d.labels[label.ID] == "" {
+					d.labels[label.ID] = labelDisplayName(label.Name, label.Color)
+				}
+			}
+		}
+		return d, nil
+	}
+
+	date, err := parseSince(since, client.Now())
+	if err != nil {
+		return nil, errors.New("--since is neither a snapshot file nor a date: " + err.Error())
+	}
+	d.Since = date.Format("2006-01-02 15:04")
+	actions, err := client.BoardActions(boardID, diffActionFilter, date)
+	if err != nil {
+		return nil, err
+	}
+	for id, s := range d.After {
+		d.Before[id] = s.copy()
+	}
+	undoActions(d.Before, actions, d)
+	return d, nil
+}
+
+// formatDue formats a due date for a change in local time
+func formatDue(due string) string {
+	if t, ok := parseTrelloDate(due); ok {
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return "none"
+}
+
+// actionChanges turns a board action into changes like the ones compare
+// finds, for the actions that diff looks at and comments
+func actionChanges(action *TrelloAction) []*CardChange {
+	data := &action.Data
+	label := labelDisplayName(data.Label.Name, data.Label.Color)
+	member := data.IDMember
+	if action.Member != nil {
+		member = action.Member.UserName
+	}
+	result := []*CardChange{}
+	add := func(change, from, to string) {
+		result = append(result, &CardChange{change, data.Card.IDCard, data.Card.CardName, data.Card.ShortLink, from, to})
+	}
+	switch action.Type {
+	case "createCard", "copyCard", "emailCard", "convertToCardFromCheckItem", "moveCardToBoard":
+		add("added", "", data.List.ListName)
+	case "deleteCard", "moveCardFromBoard":
+		add("removed", data.List.ListName, "")
+	case "commentCard":
+		add("commented", "", data.Text)
+	case "addLabelToCard":
+		add("labeled", "", label)
+	case "removeLabelFromCard":
+		add("unlabeled", label, "")
+	case "addMemberToCard":
+		add("assigned", "", "@"+member)
+	case "removeMemberFromCard":
+		add("unassigned", "@"+member, "")
+	case "updateCard":
+		if _, ok := data.Old["idList"]; ok {
+			add("moved", data.ListBefore.ListName, data.ListAfter.ListName)
+		}
+		if v, ok := data.Old["name"].(string); ok {
+			add("renamed", v, data.Card.CardName)
+		}
+		if v, ok := data.Old["due"]; ok {
+			old, _ := v.(string)
+			add("rescheduled", formatDue(old), formatDue(data.Card.Due))
+		}
+		if v, ok := data.Old["closed"].(bool); ok {
+			if v {
+				add("restored", "", data.List.ListName)
+			} else {
+				add("archived", data.List.ListName, "")
+			}
+		}
+		if v, ok := data.Old["dueComplete"].(bool); ok {
+			if v {
+				add("reopened", "", "")
+			} else {
+				add("completed", "", "")
+			}
+		}
+	}
+	return result
+}
+
+func describeChange(c *CardChange, card string) string {
+	switch c.Change {
+	case "added", "restored":
+		return card + " (" + c.To + ")"
+	case "removed", "archived":
+		return card + " (" + c.From + ")"
+	case "moved":
+		return card + ": " + c.From + " -> " + c.To
+	case "renamed":
+		return c.From + " -> " + c.To
+	case "rescheduled":
+		return card + ": due " + c.From + " -> " + c.To
+	case "commented":
+		if c.To == "" {
+			return card
+		}
+		return card + ": " + strings.Replace(c.To, "\n", " ", -1)
+	case "labeled", "assigned":
+		return card + ": +" + c.To
+	case "unlabeled", "unassigned":
+		return card + ": -" + c.From
+	}
+	return card
+}
+
+func (client *TrelloClient) diffFormatterText(d *BoardDiff) error {
+	fmt.Fprintln(client.out, "Changes on "+d.Board+" since "+d.Since)
+	for _, kind := range changeKinds {
+		header := false
+		for _, c := range d.Changes {
+			if c.Change != kind {
+				continue
+			}
+			if !header {
+				fmt.Fprintln(client.out)
+				fmt.Fprintln(client.out, strings.Title(kind))
+				header = true
+			}
+			fmt.Fprintln(client.out, "    "+describeChange(c, c.Card))
+		}
+	}
+	if len(d.Changes) == 0 {
+		fmt.Fprintln(client.out)
+		fmt.Fprintln(client.out, "No changes")
+	}
+	return nil
+}
+
+func (client *TrelloClient) diffFormatterMarkdown(d *BoardDiff) error {
+	fmt.Fprintln(client.out, "# Changes on "+d.Board+" since "+d.Since)
+	for _, kind := range changeKinds {
+		header := false
+		for _, c := range d.Changes {
+			if c.Change != kind {
+				continue
+			}
+			if !header {
+				fmt.Fprintln(client.out)
+				fmt.Fprintln(client.out, "## "+strings.Title(kind))
+				fmt.Fprintln(client.out)
+				header = true
+			}
+			card := "**" + c.Card + "**"
+			if c.ShortLink != "" {
+				card = "[" + card + "](https://trello.com/c/" + c.ShortLink + ")"
+			}
+			fmt.Fprintln(client.out, "* "+describeChange(c, card))
+		}
+	}
+	if len(d.Changes) == 0 {
+		fmt.Fprintln(client.out)
+		fmt.Fprintln(client.out, "No changes.")
+	}
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patch describes the changes as RFC 6902 JSON patch of a document
+// {"cards": {"<id>": <cardSnapshot>}}
+func (d *BoardDiff) patch() []*jsonPatchOp {
+	ids := []string{}
+	for id := range d.Before {
+		ids = append(ids, id)
+	}
+	for id := range d.After {
+		if _, ok := d.Before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	ops := []*jsonPatchOp{}
+	for _, id := range ids {
+		path := "/cards/" + id
+		b, a := d.Before[id], d.After[id]
+		switch {
+		case b == nil:
+			ops = append(ops, &jsonPatchOp{"add", path, a})
+			continue
+		case a == nil:
+			ops = append(ops, &jsonPatchOp{Op: "remove", Path: path})
+			continue
+		}
+		replace := func(field string, changed bool, value interface{}) {
+			if changed {
+				ops = append(ops, &jsonPatchOp{"replace", path + "/" + field, value})
+			}
+		}
+		onlyB, onlyA := setDiff(b.IDLabels, a.IDLabels)
+		labels := len(onlyB)+len(onlyA) > 0
+		onlyB, onlyA = setDiff(b.IDMembers, a.IDMembers)
+		members := len(onlyB)+len(onlyA) > 0
+		replace("name", b.Name != a.Name, a.Name)
+		replace("idList", b.IDList != a.IDList, a.IDList)
+		replace("closed", b.Closed != a.Closed, a.Closed)
+		replace("due", b.Due != a.Due, a.Due)
+		replace("dueComplete", b.DueComplete != a.DueComplete, a.DueComplete)
+		replace("idLabels", labels, a.IDLabels)
+		replace("idMembers", members, a.IDMembers)
+	}
+	return ops
+}
+
+func (client *TrelloClient) diffFormatterJSON(d *BoardDiff) error {
+	doc, err := json.Marshal(d.patch())
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+// Diff reports the changes of a board since a date or a snapshot, see
+// boardDiff.
+func (client *TrelloClient) Diff() error {
+	boardID, boardName, err := client.boardFromArg()
+	if err != nil {
+		return err
+	}
+	d, err := client.boardDiff(boardID, boardName)
+	if err != nil {
+		return err
+	}
+	d.compare()
+	switch strings.ToLower(client.config.Format) {
+	case "text":
+		err = client.diffFormatterText(d)
+	case "markdown":
+		err = client.diffFormatterMarkdown(d)
+	case "json":
+		err = client.diffFormatterJSON(d)
+	default:
+		err = errors.New("Format not supported for this operation.")
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package tres
+
+import (
+	"strings"
+)
+
+// boardDirectory caches the members, labels and checklists of a board so
+// that IDs on cards can be resolved to names with one request per board.
+// Every part is loaded on first use.
+type boardDirectory struct {
+	members    []*TrelloMember
+	labels     []*TrelloLabel
+	checklists []*TrelloChecklist
+	loaded     map[string]bool
+
+	customFields     []*TrelloCustomField
+	customFieldItems map[string][]*TrelloCustomFieldItem // by card ID
+}
+
+type TrelloAttachment struct {
+	ID       string `json:"id"`
+	Bytes    int    `json:"bytes"`
+	Date     string `json:"date"`
+	IDMember string `json:"idMember"`
+	IsUpload bool   `json:"isUpload"`
+	MimeType string `json:"mimeType"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+}
+
+func (client *TrelloClient) BoardLabels(boardID string) ([]*TrelloLabel, error) {
+	q := map[string]string{
+		"fields": "all",
+		"limit":  "1000",
+	}
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/labels", q)
+	result := []*TrelloLabel{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+func (client *TrelloClient) BoardChecklists(boardID string) ([]*TrelloChecklist, error) {
+	q := map[string]string{
+		"fields":     "name,idBoard,idCard,pos",
+		"checkItems": "all",
+	}
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/checklists", q)
+	result := []*TrelloChecklist{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+func (client *TrelloClient) CardAttachments(cardID string) ([]*TrelloAttachment, error) {
+	q := map[string]string{
+		"fields": "all",
+	}
+	theURL := client.prepareQuery("/1/cards/"+strings.TrimSpace(cardID)+"/attachments", q)
+	result := []*TrelloAttachment{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+func (client *TrelloClient) boardDirectory(boardID string) *boardDirectory {
+	dir, ok := client.directories[boardID]
+	if !ok {
+		dir = &boardDirectory{loaded: make(map[string]bool)}
+		client.directories[boardID] = dir
+	}
+	return dir
+}
+
+// errors are not reported, a failed lookup is remembered as empty so we
+// do not ask again for every card, e.g. when we are offline
+
+func (client *TrelloClient) directoryMembers(boardID string) []*TrelloMember {
+	dir := client.boardDirectory(boardID)
+	if !dir.loaded["members"] {
+		dir.members, _ = client.FetchBoardMembers(boardID)
+		dir.loaded["members"] = true
+	}
+	return dir.members
+}
+
+func (client *TrelloClient) directoryLabels(boardID string) []*TrelloLabel {
+	dir := client.boardDirectory(boardID)
+	if !dir.loaded["labels"] {
+		dir.labels, _ = client.BoardLabels(boardID)
+		dir.loaded["labels"] = true
+	}
+	return dir.labels
+}
+
+func (client *TrelloClient) directoryChecklists(boardID string) []*TrelloChecklist {
+	dir := client.boardDirectory(boardID)
+	if !dir.loaded["checklists"] {
+		dir.checklists, _ = client.BoardChecklists(boardID)
+		dir.loaded["checklists"] = true
+	}
+	return dir.checklists
+}
+
+func (client *TrelloClient) memberByID(boardID, memberID string) *TrelloMember {
+	for _, member := range client.directoryMembers(boardID) {
+		if member.IDMember == memberID {
+			return member
+		}
+	}
+	return nil
+}
+
+// memberUserNames resolves member IDs, unknown members keep their ID
+func (client *TrelloClient) memberUserNames(boardID string, ids []string) []string {
+	result := []string{}
+	for _, id := range ids {
+		if member := client.memberByID(boardID, id); member != nil {
+			result = append(result, member.UserName)
+		} else {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// memberInitials resolves member IDs to initials, unknown members keep their ID
+func (client *TrelloClient) memberInitials(boardID string, ids []string) []string {
+	result := []string{}
+	for _, id := range ids {
+		if member := client.memberByID(boardID, id); member != nil {
+			result = append(result, member.Initials)
+		} else {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// cardLabels returns the labels of a card, resolving idLabels through the
+// board directory if the labels are not part of the card data
+func (client *TrelloClient) cardLabels(card *TrelloCardSearchResult) []*TrelloLabel {
+	if len(card.Labels) > 0 || len(card.IDLabels) == 0 {
+		return card.Labels
+	}
+	result := []*TrelloLabel{}
+	for _, id := range card.IDLabels {
+		for _, label := range client.directoryLabels(card.IDBoard) {
+			if label.ID == id {
+				result = append(result, label)
+			}
+		}
+	}
+	return result
+}
+
+func (client *TrelloClient) checklistNames(card *TrelloCardSearchResult) []string {
+	result := []string{}
+	for _, id := range card.IDChecklists {
+		for _, chklist := range client.directoryChecklists(card.IDBoard) {
+			if chklist.IDChecklist == id {
+				result = append(result, chklist.Name)
+			}
+		}
+	}
+	return result
+}
+
+func (client *TrelloClient) attachmentNames(card *TrelloCardSearchResult) (string, error) {
+	if card.Badges == nil || card.Badges.Attachments == 0 {
+		return "", nil
+	}
+	attachments, err := client.CardAttachments(card.ID)
+	if err != nil {
+		return "", err
+	}
+	names := []string{}
+	for _, attachment := range attachments {
+		names = append(names, "["+attachment.Name+"]")
+	}
+	return strings.Join(names, " "), nil
+}
@@ -0,0 +1,229 @@
+package tres
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default columns of the due command if --fields is not given
+const dueFields = "duedate,duerelative,duecomplete,boardname,listname,name"
+
+// parseWithin reads the --within period, a number of days or a number
+// followed by h (hours), d (days) or w (weeks)
+func parseWithin(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	unit := time.Duration(24) * time.Hour
+	switch {
+	case strings.HasSuffix(s, "h"):
+		unit = time.Hour
+		s = s[:len(s)-1]
+	case strings.HasSuffix(s, "d"):
+		s = s[:len(s)-1]
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.New("Invalid --within period, use e.g. 7d, 2w or 12h")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
+
+// relativeTime describes t relative to now, e.g. "in 3 days" or "5 hours ago"
+func relativeTime(t, now time.Time) string {
+	d := t.Sub(now)
+	past := d < 0
+	if past {
+		d = -d
+	}
+	var s string
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		s = plural(int(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		s = plural(int(d/time.Hour), "hour")
+	default:
+		s = plural(int(d/(24*time.Hour)), "day")
+	}
+	if past {
+		return s + " ago"
+	}
+	return "in " + s
+}
+
+// dueQueries builds the search queries from --member and --overdue or
+// --within if no query is given on the command line, findCards adds --board.
+// Every query only finds cards with a due date, Trello search has no OR so
+// cards matching either of two due terms need two searches.
+func (client *TrelloClient) dueQueries(within time.Duration) []string {
+	terms := []string{"is:open"}
+	if client.config.Member != "" {
+		terms = append(terms, "@"+strings.TrimPrefix(client.config.Member, "@"))
+	}
+	base := strings.Join(terms, " ") + " "
+	days := "due:" + strconv.Itoa(int((within+24*time.Hour-1)/(24*time.Hour)))
+	switch {
+	case client.config.Overdue && within > 0:
+		return []string{base + "due:overdue", base + days}
+	case client.config.Overdue:
+		return []string{base + "due:overdue"}
+	case within > 0:
+		return []string{base + days}
+	}
+	return []string{base + "due:incomplete", base + "due:complete"}
+}
+
+// filterDue keeps the cards with a due date that match --overdue and --within
+func (client *TrelloClient) filterDue(cards []*TrelloCardSearchResult, within time.Duration) []*TrelloCardSearchResult {
+	now := client.Now()
+	result := []*TrelloCardSearchResult{}
+	for _, card := range cards {
+		due, ok := parseTrelloDate(card.Due)
+		if !ok {
+			continue
+		}
+		upcoming := !due.Before(now) && due.Sub(now) <= within
+		switch {
+		case client.config.Overdue && within > 0:
+			ok = isOverdue(card, now) || upcoming
+		case client.config.Overdue:
+			ok = isOverdue(card, now)
+		case within > 0:
+			ok = upcoming
+		}
+		if ok {
+			result = append(result, card)
+		}
+	}
+	return result
+}
+
+// Due lists cards by due date. The cards are taken from the query given on
+// the command line or searched with --board, --member, --overdue and --within.
+func (client *TrelloClient) Due() error {
+	var within time.Duration
+	if client.config.Within != "" {
+		var err error
+		if within, err = parseWithin(client.config.Within); err != nil {
+			return err
+		}
+	}
+	queries := client.dueQueries(within)
+	if flag.NArg() >= 2 {
+		queries = []string{flag.Arg(flag.NArg() - 1)}
+	}
+	cards := []*TrelloCardSearchResult{}
+	seen := map[string]bool{}
+	for _, query := range queries {
+		found, err := client.findCards(query)
+		if err != nil {
+			return err
+		}
+		for _, card := range found {
+			if !seen[card.ID] {
+				seen[card.ID] = true
+				cards = append(cards, card)
+			}
+		}
+	}
+	cards = client.filterDue(cards, within)
+	if strings.TrimSpace(client.config.SortFields) == "" {
+		// ISO timestamps in UTC sort fine as strings
+		sort.SliceStable(cards, func(i, j int) bool {
+			return cards[i].Due < cards[j].Due
+		})
+	}
+	if client.config.SearchResultFields == "name" {
+		client.config.SearchResultFields = dueFields
+	}
+	err := client.outputCards(cards, client.config.Format)
+	if err != nil {
+		fmt.Fprintln(client.out, "Error writing due cards:", err.Error())
+	}
+	return err
+}
+
+// icalEscape escapes a text value for an iCalendar property
+func icalEscape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, ";", "\\;", -1)
+	s = strings.Replace(s, ",", "\\,", -1)
+	s = strings.Replace(s, "\r\n", "\\n", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
+
+// icalLine folds a content line after 75 octets without splitting a UTF-8
+// sequence, lines end with CRLF
+func icalLine(line string) string {
+	result := ""
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		result += line[:cut] + "\r\n "
+		line = line[cut:]
+		limit = 74 // the leading space counts
+	}
+	return result + line + "\r\n"
+}
+
+// formatterICal writes an iCalendar file with one event per card due date.
+// Cards without due date are skipped, groups are ignored.
+func (client *TrelloClient) formatterICal(groups []*CardGroup) error {
+	const stamp = "20060102T150405Z"
+	now := client.Now().UTC().Format(stamp)
+	out := icalLine("BEGIN:VCALENDAR")
+	out += icalLine("VERSION:2.0")
+	out += icalLine("PRODID:-//tres//Trello Search//EN")
+	out += icalLine("CALSCALE:GREGORIAN")
+	out += icalLine("X-WR-CALNAME:Trello due dates")
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			due, ok := parseTrelloDate(card.Due)
+			if !ok || seen[card.ID] {
+				continue
+			}
+			seen[card.ID] = true
+			summary := card.Name
+			if card.DueComplete {
+				summary = "[done] " + summary
+			}
+			desc := client.BoardName(card) + " / " + client.ListName(card) + "\n" + card.URL
+			if strings.TrimSpace(card.Desc) != "" {
+				desc += "\n\n" + card.Desc
+			}
+			out += icalLine("BEGIN:VEVENT")
+			out += icalLine("UID:" + card.ID + "@trello.com")
+			out += icalLine("DTSTAMP:" + now)
+			out += icalLine("DTSTART:" + due.UTC().Format(stamp))
+			out += icalLine("DTEND:" + due.UTC().Add(30*time.Minute).Format(stamp))
+			out += icalLine("SUMMARY:" + icalEscape(summary))
+			out += icalLine("DESCRIPTION:" + icalEscape(desc))
+			if card.URL != "" {
+				out += icalLine("URL:" + card.URL)
+			}
+			out += icalLine("END:VEVENT")
+		}
+	}
+	out += icalLine("END:VCALENDAR")
+	fmt.Fprint(client.out, out)
+	return nil
+}
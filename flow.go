@@ -0,0 +1,536 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// action types that put a card into a list
+const listActionFilter = "createCard,copyCard,emailCard,convertToCardFromCheckItem,moveCardToBoard,updateCard:idList,updateCard:closed,moveCardFromBoard,deleteCard"
+
+// CardFlow holds the flow timestamps of a single card. Started and
+// Completed are nil if the card did not reach the start or end list.
+type CardFlow struct {
+	IDCard    string     `json:"idCard"`
+	Name      string     `json:"name"`
+	URL       string     `json:"shortUrl"`
+	List      string     `json:"list"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Completed *time.Time `json:"completed,omitempty"`
+	LeadDays  float64    `json:"leadTimeDays,omitempty"`
+	CycleDays float64    `json:"cycleTimeDays,omitempty"`
+	AgeDays   float64    `json:"ageDays,omitempty"` // for work in progress
+}
+
+type FlowPercentiles struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P85   float64 `json:"p85"`
+	P95   float64 `json:"p95"`
+}
+
+type WeeklyThroughput struct {
+	Week  string `json:"week"`
+	Count int    `json:"count"`
+}
+
+// FlowReport contains lead and cycle times of the cards completed since
+// a date, the weekly throughput and the age of the work in progress.
+type FlowReport struct {
+	Board      string              `json:"board"`
+	StartList  string              `json:"startList"`
+	EndList    string              `json:"endList"`
+	Since      time.Time           `json:"since"`
+	Completed  []*CardFlow         `json:"completed"`
+	WIP        []*CardFlow         `json:"wip"`
+	LeadTime   FlowPercentiles     `json:"leadTime"`
+	CycleTime  FlowPercentiles     `json:"cycleTime"`
+	Throughput []*WeeklyThroughput `json:"throughput"`
+}
+
+// BoardActions returns all actions of a board matching the filter that
+// happened after since (if not zero), oldest first. Trello returns at most
+// 1000 actions per request, so older pages are fetched with "before".
+func (client *TrelloClient) BoardActions(boardID, filter string, since time.Time) ([]*TrelloAction, error) {
+	result := []*TrelloAction{}
+	before := ""
+	for {
+		q := map[string]string{
+			"filter": filter,
+			"limit":  "1000",
+		}
+		if !since.IsZero() {
+			q["since"] = since.UTC().Format(time.RFC3339)
+		}
+		if before != "" {
+			q["before"] = before
+		}
+		theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/actions", q)
+		page := []*TrelloAction{}
+		resp, err := client.HTTPClient.Get(theURL.String())
+		if err = processResponse(resp, err, &page); err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+		if len(page) < 1000 {
+			break
+		}
+		before = page[len(page)-1].IDAction
+	}
+	sortActions(result)
+	return result, nil
+}
+
+// BoardLists returns the lists of a board in board order, filter is
+// open, closed or all.
+func (client *TrelloClient) BoardLists(boardID, filter string) ([]*TrelloList, error) {
+	q := map[string]string{
+		"filter": filter,
+	}
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/lists", q)
+	result := []*TrelloList{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Position < result[j].Position
+	})
+	return result, err
+}
+
+// BoardCards returns the cards of a board, filter is open, closed or all.
+func (client *TrelloClient) BoardCards(boardID, filter string) ([]*TrelloCardSearchResult, error) {
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/cards/"+filter, map[string]string{})
+	result := []*TrelloCardSearchResult{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// boardFromArg resolves the last command line argument or --board to a board ID
+func (client *TrelloClient) boardFromArg() (string, string, error) {
+	board := client.config.BoardName
+	if flag.NArg() >= 2 {
+		board = flag.Arg(flag.NArg() - 1)
+	}
+	return client.boardFromName(board)
+}
+
+// boardFromName resolves a board name or ID to the board ID and name
+func (client *TrelloClient) boardFromName(board string) (string, string, error) {
+	if board == "" {
+		return "", "", errors.New("Missing board name")
+	}
+	boardID := IDFromName(board, client.TrelloBoards)
+	if boardID == "" {
+		if NameFromID(board, client.TrelloBoards) == "" {
+			return "", "", errors.New("Unknown board " + board)
+		}
+		boardID = board
+	}
+	return boardID, NameFromID(boardID, client.TrelloBoards), nil
+}
+
+func listIndex(lists []*TrelloList, name string) int {
+	for i, list := range lists {
+		if strings.EqualFold(list.ListName, name) || list.IDList == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func listIndexByID(lists []*TrelloList, id string) int {
+	for i, list := range lists {
+		if list.IDList == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func days(d time.Duration) float64 {
+	return math.Round(d.Hours()/24*10) / 10
+}
+
+// percentiles uses the nearest-rank method
+func percentiles(values []float64) FlowPercentiles {
+	p := FlowPercentiles{Count: len(values)}
+	if len(values) == 0 {
+		return p
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := func(pct float64) float64 {
+		i := int(math.Ceil(pct/100*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	p.Mean = math.Round(sum/float64(len(sorted))*10) / 10
+	p.P50, p.P85, p.P95 = rank(50), rank(85), rank(95)
+	return p
+}
+
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// parseSince accepts the same date syntax as --where, default is 90 days ago
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if strings.TrimSpace(s) == "" {
+		return startOfDay(now).AddDate(0, 0, -90), nil
+	}
+	d, err := parseDateExpr(strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return d.resolve(now), nil
+}
+
+func (client *TrelloClient) flowReport(boardID, boardName string) (*FlowReport, error) {
+	if client.config.StartList == "" || client.config.EndList == "" {
+		return nil, errors.New("Missing --start-list or --end-list")
+	}
+	now := client.Now()
+	since, err := parseSince(client.config.Since, now)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := client.BoardLists(boardID, "all")
+	if err != nil {
+		return nil, err
+	}
+	startIdx := listIndex(lists, client.config.StartList)
+	endIdx := listIndex(lists, client.config.EndList)
+	if startIdx < 0 || endIdx < 0 {
+		return nil, errors.New("Unknown start or end list on board " + boardName)
+	}
+	if endIdx <= startIdx {
+		return nil, errors.New("The end list must be to the right of the start list")
+	}
+	// all actions, cards completed since the date may have been started long before
+	actions, err := client.BoardActions(boardID, listActionFilter, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	cards, err := client.BoardCards(boardID, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	flows := map[string]*CardFlow{}
+	flowOf := func(id, name, shortLink string) *CardFlow {
+		f, ok := flows[id]
+		if !ok {
+			f = &CardFlow{IDCard: id, Name: name, URL: "https://trello.com/c/" + shortLink}
+			f.Created, _ = cardCreated(id)
+			flows[id] = f
+		}
+		return f
+	}
+	for _, action := range actions {
+		card := action.Data.Card
+		if card.IDCard == "" {
+			continue
+		}
+		f := flowOf(card.IDCard, card.CardName, card.ShortLink)
+		at, _ := parseTrelloDate(action.Date)
+		if action.Type == "createCard" || action.Type == "copyCard" || action.Type == "emailCard" || action.Type == "convertToCardFromCheckItem" {
+			f.Created = at
+		}
+		list, ok := actionList(action)
+		if !ok {
+			continue
+		}
+		idx := listIndexByID(lists, list.IDList)
+		if idx >= startIdx && idx < endIdx && f.Started == nil {
+			f.Started = &at
+		}
+		if idx >= endIdx && f.Completed == nil {
+			f.Completed = &at
+		}
+		if idx >= 0 && idx < startIdx {
+			// moved back before the start list, the card starts again
+			f.Started, f.Completed = nil, nil
+		}
+	}
+
+	report := &FlowReport{Board: boardName, StartList: lists[startIdx].ListName, EndList: lists[endIdx].ListName, Since: since}
+	leads, cycles := []float64{}, []float64{}
+	weeks := map[string]int{}
+	for _, f := range flows {
+		if f.Completed == nil || f.Completed.Before(since) {
+			continue
+		}
+		f.LeadDays = days(f.Completed.Sub(f.Created))
+		leads = append(leads, f.LeadDays)
+		if f.Started != nil {
+			f.CycleDays = days(f.Completed.Sub(*f.Started))
+			cycles = append(cycles, f.CycleDays)
+		}
+		weeks[isoWeek(*f.Completed)]++
+		report.Completed = append(report.Completed, f)
+	}
+	sort.SliceStable(report.Completed, func(i, j int) bool {
+		return report.Completed[i].Completed.Before(*report.Completed[j].Completed)
+	})
+	report.LeadTime = percentiles(leads)
+	report.CycleTime = percentiles(cycles)
+	for t := since; !t.After(now); t = t.AddDate(0, 0, 7) {
+		week := isoWeek(t)
+		report.Throughput = append(report.Throughput, &WeeklyThroughput{week, weeks[week]})
+	}
+	if last := isoWeek(now); len(report.Throughput) == 0 || report.Throughput[len(report.Throughput)-1].Week != last {
+		report.Throughput = append(report.Throughput, &WeeklyThroughput{last, weeks[last]})
+	}
+
+	for _, card := range cards {
+		idx := listIndexByID(lists, card.IDList)
+		if idx < startIdx || idx >= endIdx {
+			continue
+		}
+		f := flowOf(card.ID, card.Name, card.ShortLink)
+		f.Name, f.URL, f.List = card.Name, card.ShortURL, lists[idx].ListName
+		started := f.Created
+		if f.Started != nil {
+			started = *f.Started
+		}
+		f.AgeDays = days(now.Sub(started))
+		report.WIP = append(report.WIP, f)
+	}
+	sort.SliceStable(report.WIP, func(i, j int) bool {
+		return report.WIP[i].AgeDays > report.WIP[j].AgeDays
+	})
+	return report, nil
+}
+
+func formatFlowDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// formatFlowTime formats a timestamp a card may not have
+func formatFlowTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatFlowDate(*t)
+}
+
+func formatDays(d float64) string {
+	return strconv.FormatFloat(d, 'f', 1, 64)
+}
+
+func (report *FlowReport) summaryLines(prefix string) []string {
+	lines := []string{}
+	line := func(name string, p FlowPercentiles) {
+		lines = append(lines, fmt.Sprintf("%s%-11s %4d cards, mean %s, 50%% %s, 85%% %s, 95%% %s days", prefix, name, p.Count,
+			formatDays(p.Mean), formatDays(p.P50), formatDays(p.P85), formatDays(p.P95)))
+	}
+	line("Lead time", report.LeadTime)
+	line("Cycle time", report.CycleTime)
+	return lines
+}
+
+func (client *TrelloClient) flowFormatterText(report *FlowReport) error {
+	fmt.Fprintf(client.out, "Flow of board %s from %q to %q since %s\n\n", report.Board, report.StartList, report.EndList, formatFlowDate(report.Since))
+	for _, line := range report.summaryLines("") {
+		fmt.Fprintln(client.out, line)
+	}
+	fmt.Fprintln(client.out)
+	fmt.Fprintln(client.out, "Throughput per week")
+	for _, w := range report.Throughput {
+		fmt.Fprintf(client.out, "  %s %4d %s\n", w.Week, w.Count, strings.Repeat("#", w.Count))
+	}
+	fmt.Fprintln(client.out)
+	fmt.Fprintln(client.out, "Work in progress by age")
+	for _, f := range report.WIP {
+		fmt.Fprintf(client.out, "  %6s days  %-20s %s\n", formatDays(f.AgeDays), f.List, f.Name)
+	}
+	fmt.Fprintln(client.out)
+	fmt.Fprintln(client.out, "Completed cards")
+	for _, f := range report.Completed {
+		fmt.Fprintf(client.out, "  %s  lead %6s  cycle %6s  %s\n", formatFlowDate(*f.Completed), formatDays(f.LeadDays), formatDays(f.CycleDays), f.Name)
+	}
+	return nil
+}
+
+func (client *TrelloClient) flowFormatterMarkdown(report *FlowReport) error {
+	linebuf := []string{}
+	linebuf = append(linebuf, "# Flow of board "+report.Board)
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "From *"+report.StartList+"* to *"+report.EndList+"* since "+formatFlowDate(report.Since))
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "| | Cards | Mean | 50% | 85% | 95% |")
+	linebuf = append(linebuf, "|---|--:|--:|--:|--:|--:|")
+	for _, v := range []struct {
+		name string
+		p    FlowPercentiles
+	}{{"Lead time (days)", report.LeadTime}, {"Cycle time (days)", report.CycleTime}} {
+		linebuf = append(linebuf, fmt.Sprintf("| %s | %d | %s | %s | %s | %s |", v.name, v.p.Count,
+			formatDays(v.p.Mean), formatDays(v.p.P50), formatDays(v.p.P85), formatDays(v.p.P95)))
+	}
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "## Throughput")
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "| Week | Cards |")
+	linebuf = append(linebuf, "|---|--:|")
+	for _, w := range report.Throughput {
+		linebuf = append(linebuf, "| "+w.Week+" | "+strconv.Itoa(w.Count)+" |")
+	}
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "## Aging work in progress")
+	linebuf = append(linebuf, "")
+	linebuf = append(linebuf, "| Card | List | Age (days) |")
+	linebuf = append(linebuf, "|---|---|--:|")
+	for _, f := range report.WIP {
+		linebuf = append(linebuf, "| ["+f.Name+"]("+f.URL+") | "+f.List+" | "+formatDays(f.AgeDays)+" |")
+	}
+	linebuf = append(linebuf, "")
+	fmt.Fprint(client.out, strings.Join(linebuf, "\n"))
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+var flowHeader = []string{"idcard", "name", "status", "list", "created", "started", "completed", "leadtimedays", "cycletimedays", "agedays"}
+
+func (f *CardFlow) columns(status string) []string {
+	cols := []string{f.IDCard, f.Name, status, f.List, formatFlowDate(f.Created), formatFlowTime(f.Started), formatFlowTime(f.Completed), "", "", ""}
+	if status == "done" {
+		cols[7] = formatDays(f.LeadDays)
+		if f.Started != nil {
+			cols[8] = formatDays(f.CycleDays)
+		}
+	} else {
+		cols[9] = formatDays(f.AgeDays)
+	}
+	return cols
+}
+
+func (client *TrelloClient) flowFormatterCsv(report *FlowReport) error {
+	fmt.Fprintln(client.out, strings.Join(flowHeader, client.config.ColSep))
+	write := func(cols []string) {
+		for i := range cols {
+			cols[i] = client.config.QuoteChar + cols[i] + client.config.QuoteChar
+		}
+		fmt.Fprint(client.out, strings.Join(cols, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	for _, f := range report.Completed {
+		write(f.columns("done"))
+	}
+	for _, f := range report.WIP {
+		write(f.columns("wip"))
+	}
+	return nil
+}
+
+func (client *TrelloClient) flowFormatterJSON(report *FlowReport) error {
+	doc, err := json.Marshal(report)
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+func addSheetRows(file *xlsx.File, name string, rows [][]string) error {
+	sheet, err := file.AddSheet(name)
+	if err != nil {
+		return err
+	}
+	for _, columns := range rows {
+		row := sheet.AddRow()
+		for _, column := range columns {
+			cell := row.AddCell()
+			cell.Value = column
+		}
+	}
+	return nil
+}
+
+func (client *TrelloClient) flowFormatterExcel(report *FlowReport) (err error) {
+	file := xlsx.NewFile()
+	summary := [][]string{
+		{"Board", report.Board},
+		{"Start list", report.StartList},
+		{"End list", report.EndList},
+		{"Since", formatFlowDate(report.Since)},
+		{},
+		{"", "Cards", "Mean", "50%", "85%", "95%"},
+	}
+	for _, v := range []struct {
+		name string
+		p    FlowPercentiles
+	}{{"Lead time (days)", report.LeadTime}, {"Cycle time (days)", report.CycleTime}} {
+		summary = append(summary, []string{v.name, strconv.Itoa(v.p.Count), formatDays(v.p.Mean), formatDays(v.p.P50), formatDays(v.p.P85), formatDays(v.p.P95)})
+	}
+	if err = addSheetRows(file, "Summary", summary); err != nil {
+		return
+	}
+	throughput := [][]string{{"Week", "Cards"}}
+	for _, w := range report.Throughput {
+		throughput = append(throughput, []string{w.Week, strconv.Itoa(w.Count)})
+	}
+	if err = addSheetRows(file, "Throughput", throughput); err != nil {
+		return
+	}
+	cards := [][]string{flowHeader}
+	for _, f := range report.Completed {
+		cards = append(cards, f.columns("done"))
+	}
+	for _, f := range report.WIP {
+		cards = append(cards, f.columns("wip"))
+	}
+	if err = addSheetRows(file, "Cards", cards); err != nil {
+		return
+	}
+	return file.Write(client.out)
+}
+
+// Flow computes lead time, cycle time, throughput and aging work in progress
+// for a board from the list moves of its cards.
+func (client *TrelloClient) Flow() error {
+	boardID, boardName, err := client.boardFromArg()
+	if err != nil {
+		return err
+	}
+	report, err := client.flowReport(boardID, boardName)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(client.config.Format) {
+	case "text":
+		err = client.flowFormatterText(report)
+	case "csv":
+		err = client.flowFormatterCsv(report)
+	case "json":
+		err = client.flowFormatterJSON(report)
+	case "excel":
+		err = client.flowFormatterExcel(report)
+	case "markdown":
+		err = client.flowFormatterMarkdown(report)
+	default:
+		err = errors.New("INVALID_OUTPUT_FORMAT")
+	}
+	return err
+}
@@ -0,0 +1,447 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CardHistory is the timeline of a single card and the time it spent in
+// each list.
+type CardHistory struct {
+	Card    *TrelloCardSearchResult `json:"-"`
+	IDCard  string                  `json:"idCard"`
+	Name    string                  `json:"name"`
+	URL     string                  `json:"shortUrl"`
+	Actions []*TrelloAction         `json:"actions"`
+	Dwell   []*ListDwell            `json:"dwell"`
+}
+
+// ListDwell is the total time a card spent in a list. A card can enter the
+// same list several times, Visits counts how often.
+type ListDwell struct {
+	IDList   string        `json:"idList"`
+	ListName string        `json:"listName"`
+	Visits   int           `json:"visits"`
+	Duration time.Duration `json:"-"`
+	Seconds  int64         `json:"seconds"`
+	Current  bool          `json:"current"` // card is still in this list
+}
+
+var (
+	cardIDPattern    = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+	shortLinkPattern = regexp.MustCompile(`^[0-9A-Za-z]{8}$`)
+	cardURLPattern   = regexp.MustCompile(`^https?://trello\.com/c/([0-9A-Za-z]{8})(/.*)?$`)
+)
+
+// cardRef returns the card ID or short link an argument names, "" if it does
+// not look like one. A short link needs a digit, without one it could be any
+// search word like "deadline", the card URL always works.
+func cardRef(arg string) string {
+	if m := cardURLPattern.FindStringSubmatch(arg); m != nil {
+		return m[1]
+	}
+	if cardIDPattern.MatchString(arg) || shortLinkPattern.MatchString(arg) && strings.ContainsAny(arg, "0123456789") {
+		return arg
+	}
+	return ""
+}
+
+func (client *TrelloClient) FetchCard(cardID string) (*TrelloCardSearchResult, error) {
+	q := map[string]string{
+		"fields": "all",
+	}
+	theURL := client.prepareQuery("/1/cards/"+strings.TrimSpace(cardID), q)
+	result := &TrelloCardSearchResult{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// CardActions returns the actions of a card, newest first. filter is a
+// comma-separated list of action types or "all".
+func (client *TrelloClient) CardActions(cardID, filter string) ([]*TrelloAction, error) {
+	q := map[string]string{
+		"filter": filter,
+		"limit":  "1000",
+	}
+	theURL := client.prepareQuery("/1/cards/"+strings.TrimSpace(cardID)+"/actions", q)
+	result := []*TrelloAction{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// cardsFromArg treats the argument as card ID, short link or card URL if it
+// looks like one and the card exists, as search query or query file otherwise
+func (client *TrelloClient) cardsFromArg(arg string) ([]*TrelloCardSearchResult, error) {
+	if ref := cardRef(arg); ref != "" && !isFile(arg) {
+		card, err := client.FetchCard(ref)
+		if err == nil {
+			return []*TrelloCardSearchResult{card}, nil
+		}
+		if status, ok := err.(*statusError); !ok || status.StatusCode != http.StatusNotFound {
+			return nil, errors.New("Could not read card " + ref + ": " + err.Error())
+		}
+	}
+	return client.findCards(arg)
+}
+
+func oldValue(action *TrelloAction, key string) (interface{}, bool) {
+	v, ok := action.Data.Old[key]
+	return v, ok
+}
+
+func quoteName(s string) string {
+	return "\"" + s + "\""
+}
+
+// describeAction renders an action as a short english sentence
+func describeAction(action *TrelloAction) string {
+	data := &action.Data
+	switch action.Type {
+	case "createCard":
+		return "created the card in list " + quoteName(data.List.ListName)
+	case "copyCard":
+		return "copied the card to list " + quoteName(data.List.ListName)
+	case "convertToCardFromCheckItem":
+		return "converted a checklist item to this card"
+	case "emailCard":
+		return "created the card by email in list " + quoteName(data.List.ListName)
+	case "deleteCard":
+		return "deleted the card"
+	case "commentCard":
+		return "commented: " + strings.Replace(data.Text, "\n", " ", -1)
+	case "addMemberToCard", "removeMemberFromCard":
+		name := data.IDMember
+		if action.Member != nil {
+			name = action.Member.UserName
+		}
+		if action.Type == "addMemberToCard" {
+			return "added member @" + name
+		}
+		return "removed member @" + name
+	case "addLabelToCard", "removeLabelFromCard":
+		name := data.Label.Name
+		if name == "" {
+			name = "[" + strings.ToUpper(data.Label.Color) + "]"
+		}
+		if action.Type == "addLabelToCard" {
+			return "added label " + name
+		}
+		return "removed label " + name
+	case "addChecklistToCard":
+		return "added checklist " + quoteName(data.Checklist.Name)
+	case "removeChecklistFromCard":
+		return "removed checklist " + quoteName(data.Checklist.Name)
+	case "updateCheckItemStateOnCard":
+		if data.CheckItem.State == "complete" {
+			return "completed " + quoteName(data.CheckItem.Name)
+		}
+		return "marked " + quoteName(data.CheckItem.Name) + " incomplete"
+	case "addAttachmentToCard":
+		return "attached " + quoteName(data.Attachment.Name)
+	case "deleteAttachmentFromCard":
+		return "removed attachment " + quoteName(data.Attachment.Name)
+	case "moveCardToBoard":
+		return "moved the card from board " + quoteName(data.BoardSource.BoardName)
+	case "moveCardFromBoard":
+		return "moved the card to board " + quoteName(data.BoardTarget.BoardName)
+	case "updateCard":
+		if data.ListAfter.IDList != "" {
+			return "moved the card from " + quoteName(data.ListBefore.ListName) + " to " + quoteName(data.ListAfter.ListName)
+		}
+		if v, ok := oldValue(action, "closed"); ok {
+			if v == true {
+				return "sent the card back to the board"
+			}
+			return "archived the card"
+		}
+		if _, ok := oldValue(action, "name"); ok {
+			return "renamed the card to " + quoteName(data.Card.CardName)
+		}
+		if _, ok := oldValue(action, "due"); ok {
+			if data.Card.Due == "" {
+				return "removed the due date"
+			}
+			return "set the due date to " + data.Card.Due
+		}
+		if _, ok := oldValue(action, "dueComplete"); ok {
+			if data.Card.DueComplete {
+				return "marked the due date complete"
+			}
+			return "marked the due date incomplete"
+		}
+		if _, ok := oldValue(action, "desc"); ok {
+			return "changed the description"
+		}
+		if _, ok := oldValue(action, "pos"); ok {
+			return "moved the card within the list"
+		}
+		return "updated the card"
+	}
+	return action.Type
+}
+
+// actionList returns the list an action moved the card into, if any
+func actionList(action *TrelloAction) (TrelloActionList, bool) {
+	switch action.Type {
+	case "createCard", "copyCard", "emailCard", "convertToCardFromCheckItem", "moveCardToBoard":
+		return action.Data.List, action.Data.List.IDList != ""
+	case "updateCard":
+		return action.Data.ListAfter, action.Data.ListAfter.IDList != ""
+	}
+	return TrelloActionList{}, false
+}
+
+// computeDwell replays the list moves of a card. actions must be sorted
+// oldest first. If the creation is not part of the actions, the card is
+// assumed to have been in the list it was first moved away from since it
+// was created.
+func computeDwell(card *TrelloCardSearchResult, actions []*TrelloAction, listName func(id string) string, now time.Time) []*ListDwell {
+	result := []*ListDwell{}
+	index := map[string]*ListDwell{}
+	var current *TrelloActionList
+	var entered time.Time
+
+	leave := func(at time.Time) {
+		if current == nil {
+			return
+		}
+		d, ok := index[current.IDList]
+		if !ok {
+			d = &ListDwell{IDList: current.IDList, ListName: current.ListName}
+			index[current.IDList] = d
+			result = append(result, d)
+		}
+		d.Visits++
+		if at.After(entered) {
+			d.Duration += at.Sub(entered)
+		}
+	}
+
+	for _, action := range actions {
+		at, ok := parseTrelloDate(action.Date)
+		if !ok {
+			continue
+		}
+		if current == nil && action.Type == "updateCard" && action.Data.ListBefore.IDList != "" {
+			before := action.Data.ListBefore
+			current = &before
+			entered, _ = cardCreated(card.ID)
+		}
+		if action.Type == "moveCardFromBoard" || action.Type == "deleteCard" {
+			leave(at)
+			current = nil
+			continue
+		}
+		if list, ok := actionList(action); ok {
+			leave(at)
+			current = &TrelloActionList{IDList: list.IDList, ListName: list.ListName}
+			entered = at
+		}
+	}
+	if current == nil && card.IDList != "" {
+		// no moves at all, the card is in its list since it was created
+		current = &TrelloActionList{IDList: card.IDList, ListName: listName(card.IDList)}
+		entered, _ = cardCreated(card.ID)
+	}
+	if current != nil && !card.Closed {
+		leave(now)
+		index[current.IDList].Current = true
+	} else if current != nil {
+		// archived cards stop the clock with their last activity
+		last, _ := parseTrelloDate(card.DateLastActivity)
+		leave(last)
+	}
+	for _, d := range result {
+		d.Seconds = int64(d.Duration / time.Second)
+	}
+	return result
+}
+
+// formatDuration prints a duration as days, hours and minutes like "3d 4h"
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "0m"
+	}
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	parts := []string{}
+	if days > 0 {
+		parts = append(parts, strconv.Itoa(days)+"d")
+	}
+	if hours > 0 {
+		parts = append(parts, strconv.Itoa(hours)+"h")
+	}
+	if minutes > 0 && days == 0 {
+		parts = append(parts, strconv.Itoa(minutes)+"m")
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortActions(actions []*TrelloAction) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].Date < actions[j].Date
+	})
+}
+
+func (client *TrelloClient) cardHistory(card *TrelloCardSearchResult) (*CardHistory, error) {
+	actions, err := client.CardActions(card.ID, "all")
+	if err != nil {
+		return nil, err
+	}
+	sortActions(actions)
+	listName := func(id string) string {
+		return NameFromID(id, client.TrelloLists[strings.ToLower(client.BoardName(card))])
+	}
+	return &CardHistory{
+		Card:    card,
+		IDCard:  card.ID,
+		Name:    card.Name,
+		URL:     card.ShortURL,
+		Actions: actions,
+		Dwell:   computeDwell(card, actions, listName, client.Now()),
+	}, nil
+}
+
+func (client *TrelloClient) historyFormatterText(histories []*CardHistory) error {
+	for _, h := range histories {
+		fmt.Fprintln(client.out, h.Name+" ("+h.URL+")")
+		fmt.Fprintln(client.out)
+		for _, action := range h.Actions {
+			fmt.Fprintf(client.out, "%-20s %-20s %s\n", formatTimestamp(action.Date), "@"+action.MemberCreator.UserName, describeAction(action))
+		}
+		fmt.Fprintln(client.out)
+		fmt.Fprintln(client.out, "Time in lists")
+		for _, d := range h.Dwell {
+			s := fmt.Sprintf("  %-25s %10s", d.ListName, formatDuration(d.Duration))
+			if d.Visits > 1 {
+				s += fmt.Sprintf(" (%d visits)", d.Visits)
+			}
+			if d.Current {
+				s += " (current)"
+			}
+			fmt.Fprintln(client.out, s)
+		}
+		fmt.Fprintln(client.out, "--------")
+	}
+	return nil
+}
+
+func (client *TrelloClient) historyFormatterMarkdown(histories []*CardHistory) error {
+	for _, h := range histories {
+		linebuf := []string{}
+		linebuf = append(linebuf, "# "+strings.TrimSpace(h.Name))
+		linebuf = append(linebuf, "")
+		linebuf = append(linebuf, "Card ["+h.URL+"]("+h.URL+")")
+		linebuf = append(linebuf, "")
+		linebuf = append(linebuf, "## Timeline")
+		linebuf = append(linebuf, "")
+		for _, action := range h.Actions {
+			linebuf = append(linebuf, " * "+formatTimestamp(action.Date)+" @"+action.MemberCreator.UserName+" "+describeAction(action))
+		}
+		linebuf = append(linebuf, "")
+		linebuf = append(linebuf, "## Time in lists")
+		linebuf = append(linebuf, "")
+		linebuf = append(linebuf, "| List | Time | Visits |")
+		linebuf = append(linebuf, "|---|--:|--:|")
+		for _, d := range h.Dwell {
+			name := d.ListName
+			if d.Current {
+				name += " (current)"
+			}
+			linebuf = append(linebuf, "| "+name+" | "+formatDuration(d.Duration)+" | "+strconv.Itoa(d.Visits)+" |")
+		}
+		linebuf = append(linebuf, "")
+		linebuf = append(linebuf, "")
+		fmt.Fprint(client.out, strings.Join(linebuf, "\n"))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return nil
+}
+
+// historyFormatterCsv writes one row per action followed by one "dwell" row
+// per list with the time spent there in the seconds column
+func (client *TrelloClient) historyFormatterCsv(histories []*CardHistory) error {
+	q := client.config.QuoteChar
+	header := []string{"idcard", "name", "date", "member", "type", "list", "description", "seconds"}
+	fmt.Fprintln(client.out, strings.Join(header, client.config.ColSep))
+	row := func(cols ...string) {
+		for i := range cols {
+			cols[i] = q + strings.Replace(cols[i], "\n", "\\n", -1) + q
+		}
+		fmt.Fprint(client.out, strings.Join(cols, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	for _, h := range histories {
+		for _, action := range h.Actions {
+			list, _ := actionList(action)
+			row(h.IDCard, h.Name, action.Date, action.MemberCreator.UserName, action.Type, list.ListName, describeAction(action), "")
+		}
+		for _, d := range h.Dwell {
+			row(h.IDCard, h.Name, "", "", "dwell", d.ListName, formatDuration(d.Duration), strconv.FormatInt(d.Seconds, 10))
+		}
+	}
+	return nil
+}
+
+func (client *TrelloClient) historyFormatterJSON(histories []*CardHistory) error {
+	doc, err := json.Marshal(histories)
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+// formatTimestamp shortens an ISO timestamp to local date and time
+func formatTimestamp(s string) string {
+	t, ok := parseTrelloDate(s)
+	if !ok {
+		return s
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// History prints the action timeline and the time spent per list for a card
+// or for all cards found by a query.
+func (client *TrelloClient) History() error {
+	if flag.NArg() < 2 {
+		return errors.New("Missing card or query for history")
+	}
+	cards, err := client.cardsFromArg(flag.Arg(flag.NArg() - 1))
+	if err != nil {
+		return err
+	}
+	histories := []*CardHistory{}
+	for _, card := range cards {
+		h, err := client.cardHistory(card)
+		if err != nil {
+			return errors.New("Could not read actions for card " + card.ShortURL + ": " + err.Error())
+		}
+		histories = append(histories, h)
+	}
+
+	switch strings.ToLower(client.config.Format) {
+	case "text":
+		err = client.historyFormatterText(histories)
+	case "csv":
+		err = client.historyFormatterCsv(histories)
+	case "json":
+		err = client.historyFormatterJSON(histories)
+	case "markdown":
+		err = client.historyFormatterMarkdown(histories)
+	default:
+		err = errors.New("Format not supported for this operation.")
+	}
+	return err
+}
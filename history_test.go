@@ -0,0 +1,48 @@
+package tres
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCardRef(t *testing.T) {
+	tests := map[string]string{
+		"5f1a2b3c4d5e6f7a8b9c0d1e":                     "5f1a2b3c4d5e6f7a8b9c0d1e",
+		"Ab3dEf9H":                                     "Ab3dEf9H",
+		"https://trello.com/c/AbcdEfgh":                "AbcdEfgh",
+		"https://trello.com/c/AbcdEfgh/12-release-2-0": "AbcdEfgh",
+		"deadline":       "",
+		"frontend":       "",
+		"Ab3dEf9":        "",
+		"board:Ab3dEf9H": "",
+	}
+	for arg, want := range tests {
+		if got := cardRef(arg); got != want {
+			t.Errorf("%q: got %q, want %q", arg, got, want)
+		}
+	}
+}
+
+func TestCardsFromArg(t *testing.T) {
+	f := &fakeTrello{responses: map[string]string{
+		"GET /1/cards/Ab3dEf9H": `{"id": "c1", "name": "Fix login bug"}`,
+		"GET /1/search":         `{"cards": [{"id": "c2", "name": "Write docs"}]}`,
+	}}
+	client := newFakeClient(f)
+	for arg, want := range map[string]string{"Ab3dEf9H": "Fix login bug", "Xy7zXy7z": "Write docs", "deadline": "Write docs"} {
+		f.requests = nil
+		cards, err := client.cardsFromArg(arg)
+		if err != nil || len(cards) != 1 || cards[0].Name != want {
+			t.Errorf("%s: got %v %v, want %s", arg, cards, err, want)
+		}
+		if arg == "deadline" && len(f.requests) != 1 {
+			t.Errorf("deadline: sent %d requests, want only the search", len(f.requests))
+		}
+	}
+
+	// other errors than 404 are not hidden by a search
+	client.HTTPClient = &http.Client{Transport: &fakeTrello{responses: map[string]string{}, status: http.StatusUnauthorized}}
+	if _, err := client.cardsFromArg("Ab3dEf9H"); err == nil {
+		t.Error("no error for a failed request")
+	}
+}
@@ -0,0 +1,227 @@
+package tres
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// cardDetails is everything the markdown and html formatters show for a
+// card. Comments and checklists need one request each and are only read if
+// the badges say the card has some.
+type cardDetails struct {
+	Card          *TrelloCardSearchResult
+	BoardName     string
+	ListName      string
+	Members       []string
+	Labels        []*TrelloLabel
+	Comments      []*TrelloCardComment
+	CommentsErr   error
+	Checklists    []*TrelloChecklist
+	ChecklistsErr error
+	CustomFields  [][2]string // name and value, sorted by name
+}
+
+func (client *TrelloClient) cardDetails(card *TrelloCardSearchResult) *cardDetails {
+	d := &cardDetails{
+		Card:      card,
+		BoardName: client.BoardName(card),
+		ListName:  client.ListName(card),
+		Members:   client.MemberNames(card),
+		Labels:    client.cardLabels(card),
+	}
+	if card.Badges != nil && card.Badges.Comments > 0 {
+		d.Comments, d.CommentsErr = client.CardComments(card.ID)
+	}
+	if card.Badges != nil && card.Badges.CheckItems > 0 {
+		d.Checklists, d.ChecklistsErr = client.CardChecklists(card.ID)
+	}
+	names := []string{}
+	for name := range card.CustomFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.CustomFields = append(d.CustomFields, [2]string{name, fmt.Sprint(card.CustomFields[name])})
+	}
+	return d
+}
+
+// labelColors are the colors of the Trello web interface
+var labelColors = map[string]string{
+	"green":  "#61bd4f",
+	"yellow": "#f2d600",
+	"orange": "#ff9f1a",
+	"red":    "#eb5a46",
+	"purple": "#c377e0",
+	"blue":   "#0079bf",
+	"sky":    "#00c2e0",
+	"lime":   "#51e898",
+	"pink":   "#ff78cb",
+	"black":  "#344563",
+}
+
+func labelStyle(color string) template.CSS {
+	background, ok := labelColors[strings.ToLower(color)]
+	if !ok {
+		background = "#b3bac5" // no color or one we do not know
+	}
+	foreground := "#fff"
+	switch strings.ToLower(color) {
+	case "yellow", "lime", "sky", "":
+		foreground = "#172b4d"
+	}
+	return template.CSS("background-color: " + background + "; color: " + foreground + ";")
+}
+
+func labelText(label *TrelloLabel) string {
+	if label.Name == "" {
+		return strings.ToUpper(label.Color)
+	}
+	return label.Name
+}
+
+// checklistProgress is the percentage of complete items
+func checklistProgress(chklist *TrelloChecklist) int {
+	if len(chklist.CheckItems) == 0 {
+		return 0
+	}
+	done := 0
+	for _, item := range chklist.CheckItems {
+		if item.State == "complete" {
+			done++
+		}
+	}
+	return done * 100 / len(chklist.CheckItems)
+}
+
+func localDate(s string) string {
+	if t, ok := parseTrelloDate(s); ok {
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return s
+}
+
+type htmlSection struct {
+	Name     string
+	Sections []*htmlSection
+	Cards    []*cardDetails
+}
+
+var htmlFuncs = template.FuncMap{
+	"labelStyle": labelStyle,
+	"labelText":  labelText,
+	"progress":   checklistProgress,
+	"date":       localDate,
+	"join":       strings.Join,
+}
+
+var htmlTemplate = template.Must(template.New("cards").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #172b4d; background: #f4f5f7; margin: 0 auto; max-width: 960px; padding: 1em 2em; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.3em; border-bottom: 2px solid #dfe1e6; padding-bottom: .2em; margin-top: 1.5em; }
+h3 { font-size: 1.1em; color: #5e6c84; }
+.card { background: #fff; border-radius: 4px; box-shadow: 0 1px 2px rgba(9,30,66,.25); padding: .8em 1em; margin: .8em 0; }
+.card h4 { font-size: 1.05em; margin: 0 0 .4em 0; }
+.card h4 a { color: inherit; text-decoration: none; }
+.label { display: inline-block; border-radius: 3px; padding: 0 .5em; margin: 0 .3em .3em 0; font-size: .8em; font-weight: bold; line-height: 1.6em; }
+.meta { color: #5e6c84; font-size: .85em; margin: .3em 0; }
+.desc, .comment .text { white-space: pre-wrap; }
+.checklist { margin: .6em 0; }
+.checklist .title { font-weight: bold; font-size: .9em; }
+.bar { background: #dfe1e6; border-radius: 4px; height: 8px; margin: .3em 0; }
+.bar div { background: #5aac44; border-radius: 4px; height: 8px; }
+.checklist ul { list-style: none; padding-left: .5em; margin: .3em 0; }
+.complete { text-decoration: line-through; color: #5e6c84; }
+.comment { border-left: 3px solid #dfe1e6; padding-left: .8em; margin: .6em 0; }
+.comment .meta { margin: 0; }
+.error { color: #eb5a46; }
+table { border-collapse: collapse; font-size: .85em; }
+td { padding: .1em 1em .1em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Count}} cards, generated {{.Generated}}</p>
+{{range .Sections}}{{template "section" .}}{{end}}
+</body>
+</html>
+{{define "section"}}{{if .Name}}<h2>{{.Name}}</h2>
+{{end}}{{range .Sections}}<h3>{{.Name}}</h3>
+{{range .Cards}}{{template "card" .}}{{end}}{{end}}{{range .Cards}}{{template "card" .}}{{end}}{{end}}
+{{define "card"}}<div class="card">
+<h4><a href="{{.Card.ShortURL}}">{{.Card.Name}}</a></h4>
+{{if .Labels}}<div>{{range .Labels}}<span class="label" style="{{labelStyle .Color}}">{{labelText .}}</span>{{end}}</div>
+{{end}}<div class="meta">{{.BoardName}} &rsaquo; {{.ListName}}{{if .Card.Due}} &middot; due {{date .Card.Due}}{{if .Card.DueComplete}} (done){{end}}{{end}}{{if .Members}} &middot; {{join .Members ", "}}{{end}}{{if .Card.DateLastActivity}} &middot; last activity {{date .Card.DateLastActivity}}{{end}}</div>
+{{if .Card.Desc}}<div class="desc">{{.Card.Desc}}</div>
+{{end}}{{if .CustomFields}}<table>{{range .CustomFields}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>{{end}}</table>
+{{end}}{{if .ChecklistsErr}}<p class="error">Could not read checklist items for card: {{.ChecklistsErr}}</p>
+{{end}}{{range .Checklists}}<div class="checklist"><div class="title">{{.Name}} ({{progress .}}%)</div>
+<div class="bar"><div style="width: {{progress .}}%"></div></div>
+<ul>{{range .CheckItems}}<li{{if eq .State "complete"}} class="complete"{{end}}>{{if eq .State "complete"}}&#x2611;{{else}}&#x2610;{{end}} {{.Name}}</li>{{end}}</ul></div>
+{{end}}{{if .CommentsErr}}<p class="error">Could not read comments for card: {{.CommentsErr}}</p>
+{{end}}{{range .Comments}}<div class="comment"><p class="meta">@{{.MemberCreator.UserName}}, {{date .Date}}</p><div class="text">{{.Data.Text}}</div></div>
+{{end}}</div>
+{{end}}`))
+
+// htmlSections builds the sections of the page, the groups of --group-by or
+// boards with their lists in the order of the cards
+func (client *TrelloClient) htmlSections(groups []*CardGroup) []*htmlSection {
+	sections := []*htmlSection{}
+	if client.isGrouped() {
+		for _, group := range groups {
+			section := &htmlSection{Name: group.Name}
+			for _, card := range group.Cards {
+				section.Cards = append(section.Cards, client.cardDetails(card))
+			}
+			sections = append(sections, section)
+		}
+		return sections
+	}
+	boards := map[string]*htmlSection{}
+	lists := map[string]*htmlSection{}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			d := client.cardDetails(card)
+			board, ok := boards[card.IDBoard]
+			if !ok {
+				board = &htmlSection{Name: d.BoardName}
+				boards[card.IDBoard] = board
+				sections = append(sections, board)
+			}
+			list, ok := lists[card.IDList]
+			if !ok {
+				list = &htmlSection{Name: d.ListName}
+				lists[card.IDList] = list
+				board.Sections = append(board.Sections, list)
+			}
+			list.Cards = append(list.Cards, d)
+		}
+	}
+	return sections
+}
+
+// formatterHTML writes a standalone HTML page with styles, the cards with
+// labels, description, checklists and comments
+func (client *TrelloClient) formatterHTML(groups []*CardGroup) error {
+	count := 0
+	for _, group := range groups {
+		count += len(group.Cards)
+	}
+	return client.writeHTML("Trello cards", count, client.htmlSections(groups))
+}
+
+func (client *TrelloClient) writeHTML(title string, count int, sections []*htmlSection) error {
+	return htmlTemplate.Execute(client.out, map[string]interface{}{
+		"Title":     title,
+		"Count":     count,
+		"Generated": client.Now().Format("2006-01-02 15:04"),
+		"Sections":  sections,
+	})
+}
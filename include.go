@@ -0,0 +1,185 @@
+package tres
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// queryFile assembles the search query from the lines of a query file
+// and the files it includes
+type queryFile struct {
+	client    *TrelloClient
+	fragments map[string][]string // @define name -> lines
+	files     []string            // the files being read, to detect cycles
+	using     map[string]bool     // the fragments being used, to detect cycles
+	query     string
+	sections  []*querySection
+	base      *Config // the settings before the first section
+	common    string  // the query before the first section
+}
+
+// querySection is a "@section Title" of a query file, the settings and the
+// query of its lines up to the next section. Settings and query text before
+// the first section apply to all sections.
+type querySection struct {
+	Title  string
+	Config *Config
+	Query  string
+}
+
+// endSection keeps the settings and query of the current section
+func (p *queryFile) endSection() {
+	if len(p.sections) == 0 {
+		return
+	}
+	section := p.sections[len(p.sections)-1]
+	config := *p.client.config
+	section.Config = &config
+	section.Query = p.query
+}
+
+// section starts a new section with the settings and query from before the
+// first section
+func (p *queryFile) section(title string) error {
+	title = strings.TrimSpace(strings.Trim(title, "\""))
+	if title == "" {
+		return errors.New("Missing title, use: @section \"<title>\"")
+	}
+	for _, section := range p.sections {
+		if strings.EqualFold(section.Title, title) {
+			return errors.New("Duplicate @section " + title)
+		}
+	}
+	p.endSection()
+	if p.base == nil {
+		base := *p.client.config
+		p.base = &base
+		p.common = p.query
+	}
+	*p.client.config = *p.base
+	p.query = p.common
+	p.sections = append(p.sections, &querySection{Title: title})
+	return nil
+}
+
+// atCommandArg splits an at-command line into the lower case command and
+// its argument
+func atCommandArg(line string) (string, string) {
+	cmd := strings.Fields(line)[0]
+	return strings.ToLower(cmd), strings.TrimSpace(line[len(cmd):])
+}
+
+// parseFile parses the content of a file, unless the file is already being
+// read because it includes itself
+func (p *queryFile) parseFile(content, filename string) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	for _, f := range p.files {
+		if f == path {
+			return errors.New("Cyclic @include of " + filename)
+		}
+	}
+	p.files = append(p.files, path)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+	return p.parse(strings.Split(content, "\n"), filepath.Dir(path))
+}
+
+// include reads a file for @include, relative paths are relative to dir
+func (p *queryFile) include(filename, dir string) error {
+	filename = strings.Trim(filename, "\"")
+	if filename == "" {
+		return errors.New("Missing file name, use: @include <file>")
+	}
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.New("Could not @include: " + err.Error())
+	}
+	return p.parseFile(string(data), filename)
+}
+
+// use parses the lines of a fragment
+func (p *queryFile) use(name, dir string) error {
+	lines, ok := p.fragments[name]
+	if !ok {
+		return errors.New("Undefined fragment " + name + ", use @define first")
+	}
+	if p.using[name] {
+		return errors.New("Fragment " + name + " uses itself")
+	}
+	p.using[name] = true
+	defer delete(p.using, name)
+	return p.parse(lines, dir)
+}
+
+// parse runs the at-commands and adds the other lines to the query. Lines
+// are expanded when they are read, the lines of a fragment when it is used.
+func (p *queryFile) parse(lines []string, dir string) error {
+	for i := 0; i < len(lines); i++ {
+		line := stripComment(strings.TrimSpace(lines[i]))
+		if line == "" {
+			continue
+		}
+		if line[0] == '@' {
+			switch cmd, arg := atCommandArg(line); cmd {
+			case "@define":
+				// "@define name text" or "@define name" and lines up to @end
+				fields := strings.Fields(arg)
+				if len(fields) == 0 || !varName.MatchString(fields[0]) {
+					return errors.New("Invalid @define, use: @define <name> [<text>]")
+				}
+				name := fields[0]
+				if text := strings.TrimSpace(arg[len(name):]); text != "" {
+					p.fragments[name] = []string{text}
+					continue
+				}
+				block := []string{}
+				for i++; i < len(lines); i++ {
+					if next := stripComment(strings.TrimSpace(lines[i])); strings.ToLower(next) == "@end" {
+						break
+					}
+					block = append(block, lines[i])
+				}
+				if i == len(lines) {
+					return errors.New("Missing @end for @define " + name)
+				}
+				p.fragments[name] = block
+				continue
+			case "@end":
+				return errors.New("@end without @define")
+			}
+		}
+
+		line, err := p.client.expandVars(line)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] != '@' {
+			p.query += line + " "
+			continue
+		}
+		switch cmd, arg := atCommandArg(line); cmd {
+		case "@include":
+			err = p.include(arg, dir)
+		case "@use":
+			err = p.use(arg, dir)
+		case "@section":
+			err = p.section(arg)
+		default:
+			err = p.client.handleAtCommand(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
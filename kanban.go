@@ -0,0 +1,338 @@
+package tres
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultColumns = 120 // output width if it is no terminal and $COLUMNS is not set
+	kanbanMinWidth = 24  // narrowest column, more lists are shown in several rows
+	kanbanGap      = 2
+)
+
+// labelANSI are the 256 color codes closest to the Trello label colors
+var labelANSI = map[string]int{
+	"green":  71,
+	"yellow": 220,
+	"orange": 214,
+	"red":    203,
+	"purple": 176,
+	"blue":   32,
+	"sky":    38,
+	"lime":   120,
+	"pink":   212,
+	"black":  59,
+}
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiDim   = "\x1b[2m"
+)
+
+// kanbanText is a piece of output with its width on the terminal, which is
+// less than its length if it contains escape sequences
+type kanbanText struct {
+	text  string
+	width int
+}
+
+func plainText(s string) kanbanText {
+	return kanbanText{s, utf8.RuneCountInString(s)}
+}
+
+func ansiText(s, style string, color bool) kanbanText {
+	t := plainText(s)
+	if color {
+		t.text = style + s + ansiReset
+	}
+	return t
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// terminal returns the file client.out writes to if it is a terminal
+func (client *TrelloClient) terminal() (*os.File, bool) {
+	f, ok := client.out.(*os.File)
+	return f, ok && isTerminal(f)
+}
+
+// kanbanLayout returns the output width and if colors are used. Colors are
+// only written to a terminal and not if $NO_COLOR is set.
+func (client *TrelloClient) kanbanLayout() (int, bool) {
+	width, color := 0, false
+	if f, ok := client.terminal(); ok {
+		width, _ = terminalSize(f)
+		color = os.Getenv("NO_COLOR") == ""
+	}
+	if width == 0 {
+		if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+			width = n
+		} else {
+			width = defaultColumns
+		}
+	}
+	return width, color
+}
+
+// truncate cuts s to width runes, with an ellipsis if anything is cut
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	r := []rune(s)
+	return string(r[:width-1]) + "…"
+}
+
+// wrapText breaks s into lines of at most width runes at blanks, words
+// longer than a line are split
+func wrapText(s string, width int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			r := []rune(word)
+			lines = append(lines, string(r[:width]))
+			word = string(r[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// kanbanBadges are the labels, member initials and due date of a card,
+// each cut to width runes
+func (client *TrelloClient) kanbanBadges(card *TrelloCardSearchResult, width int, color bool) []kanbanText {
+	badges := []kanbanText{}
+	for _, label := range client.cardLabels(card) {
+		if code, ok := labelANSI[strings.ToLower(label.Color)]; ok && color {
+			badges = append(badges, kanbanText{"\x1b[48;5;" + strconv.Itoa(code) + "m  " + ansiReset, 2})
+		} else {
+			badges = append(badges, plainText(truncate("["+labelText(label)+"]", width)))
+		}
+	}
+	for _, initials := range client.memberInitials(card.IDBoard, card.IDMembers) {
+		badges = append(badges, ansiText(truncate(initials, width), ansiDim, color))
+	}
+	if due, ok := parseTrelloDate(card.Due); ok {
+		s := truncate("due "+due.Local().Format("Jan 2"), width-2)
+		switch {
+		case card.DueComplete:
+			badges = append(badges, ansiText(s+" ✓", ansiGreen, color))
+		case isOverdue(card, client.Now()):
+			if !color {
+				s += "!"
+			}
+			badges = append(badges, ansiText(s, ansiRed, color))
+		default:
+			badges = append(badges, plainText(s))
+		}
+	}
+	return badges
+}
+
+// kanbanCard renders a card for a column of the given width
+func (client *TrelloClient) kanbanCard(card *TrelloCardSearchResult, width int, color bool) []kanbanText {
+	lines := []kanbanText{}
+	for i, line := range wrapText(strings.TrimSpace(card.Name), width-2) {
+		prefix := "  "
+		if i == 0 {
+			prefix = "- "
+		}
+		lines = append(lines, plainText(prefix+line))
+	}
+	line := plainText(" ")
+	// a badge follows a blank on a line that starts with one
+	for _, badge := range client.kanbanBadges(card, width-2, color) {
+		if line.width > 1 && line.width+1+badge.width > width {
+			lines = append(lines, line)
+			line = plainText(" ")
+		}
+		line.text += " " + badge.text
+		line.width += 1 + badge.width
+	}
+	if line.width > 1 {
+		lines = append(lines, line)
+	}
+	return append(lines, plainText(""))
+}
+
+// renderKanban writes the groups as columns side by side, as many as fit
+// next to each other with kanbanMinWidth and the rest below
+func (client *TrelloClient) renderKanban(columns []*CardGroup) {
+	total, color := client.kanbanLayout()
+	perRow := (total + kanbanGap) / (kanbanMinWidth + kanbanGap)
+	if perRow < 1 {
+		perRow = 1
+	}
+	if perRow > len(columns) {
+		perRow = len(columns)
+	}
+	if perRow == 0 {
+		return
+	}
+	width := (total - kanbanGap*(perRow-1)) / perRow
+	if width < 4 {
+		width = 4
+	}
+	rule := strings.Repeat("-", width)
+	if color {
+		rule = strings.Repeat("─", width)
+	}
+
+	for start := 0; start < len(columns); start += perRow {
+		if start > 0 {
+			client.out.Write([]byte("\n"))
+		}
+		end := start + perRow
+		if end > len(columns) {
+			end = len(columns)
+		}
+		cells := [][]kanbanText{}
+		rows := 0
+		for _, column := range columns[start:end] {
+			title := truncate(column.Name+" ("+strconv.Itoa(len(column.Cards))+")", width)
+			cell := []kanbanText{ansiText(title, ansiBold, color), plainText(rule)}
+			for _, card := range column.Cards {
+				cell = append(cell, client.kanbanCard(card, width, color)...)
+			}
+			cells = append(cells, cell)
+			if len(cell) > rows {
+				rows = len(cell)
+			}
+		}
+		for row := 0; row < rows; row++ {
+			line := ""
+			pad := 0
+			for _, cell := range cells {
+				line += strings.Repeat(" ", pad)
+				pad = kanbanGap
+				if row < len(cell) {
+					line += cell[row].text
+					if cell[row].width < width {
+						pad += width - cell[row].width
+					}
+				} else {
+					pad += width
+				}
+			}
+			client.out.Write([]byte(strings.TrimRight(line, " ") + "\n"))
+		}
+	}
+}
+
+// formatterKanban shows the groups of --group-by as columns, without
+// grouping every list is a column
+func (client *TrelloClient) formatterKanban(groups []*CardGroup) error {
+	if client.isGrouped() {
+		client.renderKanban(groups)
+		return nil
+	}
+	columns := []*CardGroup{}
+	index := map[string]*CardGroup{}
+	boards := map[string]bool{}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			boards[card.IDBoard] = true
+		}
+	}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			column, ok := index[card.IDList]
+			if !ok {
+				name := client.ListName(card)
+				if len(boards) > 1 {
+					name = client.BoardName(card) + " / " + name
+				}
+				column = &CardGroup{Name: name}
+				index[card.IDList] = column
+				columns = append(columns, column)
+			}
+			column.Cards = append(column.Cards, card)
+		}
+	}
+	client.renderKanban(columns)
+	return nil
+}
+
+// Board runs the board subcommands, so far "board show <board>" which
+// prints the open lists of a board side by side with their cards
+func (client *TrelloClient) Board() error {
+	if flag.NArg() < 2 || strings.ToLower(flag.Arg(1)) != "show" {
+		return errors.New("Unknown board command, use: tres board show <board>")
+	}
+	format := strings.ToLower(client.config.Format)
+	if format != "text" && format != "kanban" {
+		return errors.New("Format not supported for this operation.")
+	}
+	board := client.config.BoardName
+	if flag.NArg() >= 3 {
+		board = flag.Arg(2)
+	}
+	boardID, _, err := client.boardFromName(board)
+	if err != nil {
+		return err
+	}
+	lists, err := client.BoardLists(boardID, "open")
+	if err != nil {
+		return errors.New("Could not read lists: " + err.Error())
+	}
+	cards, err := client.BoardCards(boardID, "open")
+	if err == nil {
+		cards, err = client.applyWhere(cards)
+	}
+	if err != nil {
+		return errors.New("Could not read cards: " + err.Error())
+	}
+	if strings.TrimSpace(client.config.SortFields) == "" {
+		sort.SliceStable(cards, func(i, j int) bool {
+			return cards[i].Pos < cards[j].Pos
+		})
+	} else if err = client.sortCards(cards); err != nil {
+		return err
+	}
+
+	columns := []*CardGroup{}
+	index := map[string]*CardGroup{}
+	for _, list := range lists {
+		column := &CardGroup{Name: list.ListName}
+		index[list.IDList] = column
+		columns = append(columns, column)
+	}
+	for _, card := range cards {
+		if column, ok := index[card.IDList]; ok {
+			column.Cards = append(column.Cards, card)
+		}
+	}
+	client.renderKanban(columns)
+	return nil
+}
@@ -0,0 +1,606 @@
+package tres
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QuerySyntaxError reports a malformed search query. Pos is the 1-based
+// character position in the query string where the problem was detected.
+type QuerySyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// QueryContext supplies everything the local query engine needs to know
+// about a card that is not part of the card itself.
+type QueryContext interface {
+	BoardName(card *TrelloCardSearchResult) string
+	ListName(card *TrelloCardSearchResult) string
+	MemberNames(card *TrelloCardSearchResult) []string
+	Me() string
+	Now() time.Time
+}
+
+// SearchQuery is a parsed Trello search query that can be evaluated
+// against cards without asking the Trello search index.
+type SearchQuery struct {
+	Text string
+	root queryNode
+}
+
+type queryTokenKind int
+
+const (
+	tokenTerm queryTokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+	tokenEOF
+)
+
+type queryToken struct {
+	kind   queryTokenKind
+	pos    int
+	key    string // operator name for "key:value" terms, empty for plain text
+	value  string
+	valPos int
+}
+
+// operators understood by the local engine, aliases map to their canonical name
+var queryOperators = map[string]string{
+	"board":       "board",
+	"list":        "list",
+	"label":       "label",
+	"member":      "member",
+	"due":         "due",
+	"edited":      "edited",
+	"created":     "created",
+	"is":          "is",
+	"has":         "has",
+	"name":        "name",
+	"description": "description",
+	"desc":        "description",
+	"comment":     "comment",
+	"checklist":   "checklist",
+}
+
+type queryLexer struct {
+	input []rune
+	pos   int
+}
+
+func isQueryDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')'
+}
+
+func (lex *queryLexer) readQuoted() (string, error) {
+	start := lex.pos
+	lex.pos++ // opening quote
+	for lex.pos < len(lex.input) {
+		if lex.input[lex.pos] == '"' {
+			s := string(lex.input[start+1 : lex.pos])
+			lex.pos++
+			return s, nil
+		}
+		lex.pos++
+	}
+	return "", &QuerySyntaxError{start + 1, "unterminated quoted phrase"}
+}
+
+func (lex *queryLexer) readWord() string {
+	start := lex.pos
+	for lex.pos < len(lex.input) && !isQueryDelimiter(lex.input[lex.pos]) && lex.input[lex.pos] != '"' {
+		lex.pos++
+	}
+	return string(lex.input[start:lex.pos])
+}
+
+// readValue reads the value of an operator term, either quoted or a single word
+func (lex *queryLexer) readValue(key string, keyPos int) (string, int, error) {
+	valPos := lex.pos + 1
+	if lex.pos >= len(lex.input) || isQueryDelimiter(lex.input[lex.pos]) {
+		return "", valPos, &QuerySyntaxError{keyPos, "missing value for " + key + ":"}
+	}
+	if lex.input[lex.pos] == '"' {
+		s, err := lex.readQuoted()
+		if err == nil && strings.TrimSpace(s) == "" {
+			err = &QuerySyntaxError{valPos, "empty value for " + key + ":"}
+		}
+		return s, valPos, err
+	}
+	return lex.readWord(), valPos, nil
+}
+
+func (lex *queryLexer) next() (queryToken, error) {
+	for lex.pos < len(lex.input) && unicode.IsSpace(lex.input[lex.pos]) {
+		lex.pos++
+	}
+	if lex.pos >= len(lex.input) {
+		return queryToken{kind: tokenEOF, pos: lex.pos + 1}, nil
+	}
+	start := lex.pos
+	tok := queryToken{kind: tokenTerm, pos: start + 1}
+	switch r := lex.input[lex.pos]; {
+	case r == '(':
+		lex.pos++
+		tok.kind = tokenOpen
+	case r == ')':
+		lex.pos++
+		tok.kind = tokenClose
+	case r == '-' && lex.pos+1 < len(lex.input) && !isQueryDelimiter(lex.input[lex.pos+1]):
+		lex.pos++
+		tok.kind = tokenNot
+	case r == '"':
+		s, err := lex.readQuoted()
+		if err != nil {
+			return tok, err
+		}
+		tok.value, tok.valPos = s, start+2
+	case r == '#' || r == '@':
+		lex.pos++
+		tok.key = "label"
+		if r == '@' {
+			tok.key = "member"
+		}
+		var err error
+		tok.value, tok.valPos, err = lex.readValue(string(r), start+1)
+		if err != nil {
+			return tok, err
+		}
+	default:
+		word := lex.readWord()
+		upper := strings.ToUpper(word)
+		if upper == "AND" || upper == "OR" {
+			tok.kind = tokenAnd
+			if upper == "OR" {
+				tok.kind = tokenOr
+			}
+			return tok, nil
+		}
+		if i := strings.Index(word, ":"); i > 0 {
+			if key, ok := queryOperators[strings.ToLower(word[:i])]; ok {
+				tok.key = key
+				rest := word[i+1:]
+				if rest == "" {
+					var err error
+					tok.value, tok.valPos, err = lex.readValue(key, start+1)
+					if err != nil {
+						return tok, err
+					}
+				} else {
+					tok.value, tok.valPos = rest, start+i+2
+				}
+				return tok, nil
+			}
+		}
+		tok.value, tok.valPos = word, start+1
+	}
+	return tok, nil
+}
+
+type queryParser struct {
+	lex *queryLexer
+	tok queryToken
+}
+
+func (p *queryParser) advance() error {
+	var err error
+	p.tok, err = p.lex.next()
+	return err
+}
+
+// ParseSearchQuery parses a query written in Trello search syntax.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	p := &queryParser{lex: &queryLexer{input: []rune(query)}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenEOF {
+		return nil, &QuerySyntaxError{p.tok.pos, "empty query"}
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	switch p.tok.kind {
+	case tokenEOF:
+	case tokenClose:
+		return nil, &QuerySyntaxError{p.tok.pos, "unexpected ')'"}
+	default:
+		return nil, &QuerySyntaxError{p.tok.pos, "unexpected token"}
+	}
+	return &SearchQuery{Text: query, root: root}, nil
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokenOr {
+		pos := p.tok.pos
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.startsUnary() {
+			return nil, &QuerySyntaxError{pos, "expected search term after OR"}
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) startsUnary() bool {
+	return p.tok.kind == tokenTerm || p.tok.kind == tokenNot || p.tok.kind == tokenOpen
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	if !p.startsUnary() {
+		return nil, p.unexpected()
+	}
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.tok.kind == tokenAnd {
+			pos := p.tok.pos
+			if err = p.advance(); err != nil {
+				return nil, err
+			}
+			if !p.startsUnary() {
+				return nil, &QuerySyntaxError{pos, "expected search term after AND"}
+			}
+		} else if !p.startsUnary() {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryAnd{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	switch p.tok.kind {
+	case tokenNot:
+		pos := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.startsUnary() {
+			return nil, &QuerySyntaxError{pos, "expected search term after '-'"}
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNot{node}, nil
+	case tokenOpen:
+		pos := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenClose {
+			return nil, &QuerySyntaxError{p.tok.pos, "empty parentheses"}
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenClose {
+			return nil, &QuerySyntaxError{pos, "missing ')' for this '('"}
+		}
+		return node, p.advance()
+	case tokenTerm:
+		node, err := newQueryTerm(p.tok)
+		if err != nil {
+			return nil, err
+		}
+		return node, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *queryParser) unexpected() error {
+	switch p.tok.kind {
+	case tokenEOF:
+		return &QuerySyntaxError{p.tok.pos, "unexpected end of query"}
+	case tokenClose:
+		return &QuerySyntaxError{p.tok.pos, "unexpected ')'"}
+	case tokenAnd:
+		return &QuerySyntaxError{p.tok.pos, "unexpected AND"}
+	case tokenOr:
+		return &QuerySyntaxError{p.tok.pos, "unexpected OR"}
+	}
+	return &QuerySyntaxError{p.tok.pos, "unexpected token"}
+}
+
+type queryNode interface {
+	match(card *TrelloCardSearchResult, ctx QueryContext) bool
+}
+
+type queryAnd struct{ left, right queryNode }
+type queryOr struct{ left, right queryNode }
+type queryNot struct{ node queryNode }
+
+type queryTerm struct {
+	key   string
+	value string
+	days  int // time window for due:, edited: and created:
+}
+
+func (n *queryAnd) match(card *TrelloCardSearchResult, ctx QueryContext) bool {
+	return n.left.match(card, ctx) && n.right.match(card, ctx)
+}
+
+func (n *queryOr) match(card *TrelloCardSearchResult, ctx QueryContext) bool {
+	return n.left.match(card, ctx) || n.right.match(card, ctx)
+}
+
+func (n *queryNot) match(card *TrelloCardSearchResult, ctx QueryContext) bool {
+	return !n.node.match(card, ctx)
+}
+
+var queryPeriods = map[string]int{
+	"day":   1,
+	"week":  7,
+	"month": 28,
+}
+
+func newQueryTerm(tok queryToken) (*queryTerm, error) {
+	term := &queryTerm{key: tok.key, value: strings.ToLower(tok.value)}
+	invalid := func(allowed string) error {
+		return &QuerySyntaxError{tok.valPos, fmt.Sprintf("invalid value %q for %s: (expected %s)", tok.value, tok.key, allowed)}
+	}
+	switch tok.key {
+	case "due", "edited", "created":
+		if days, ok := queryPeriods[term.value]; ok {
+			term.days = days
+		} else if days, err := strconv.Atoi(term.value); err == nil && days > 0 {
+			term.days = days
+		} else if tok.key != "due" || (term.value != "overdue" && term.value != "complete" && term.value != "incomplete") {
+			if tok.key == "due" {
+				return nil, invalid("day, week, month, overdue, complete, incomplete or a number of days")
+			}
+			return nil, invalid("day, week, month or a number of days")
+		}
+	case "is":
+		switch term.value {
+		case "open", "archived", "closed":
+		default:
+			return nil, invalid("open, archived or closed")
+		}
+	case "has":
+		switch term.value {
+		case "attachments", "description", "cover", "members", "checklists":
+		default:
+			return nil, invalid("attachments, description, cover, members or checklists")
+		}
+	case "comment", "checklist":
+		return nil, &QuerySyntaxError{tok.pos, tok.key + ": is not supported by the local query engine"}
+	}
+	return term, nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+// cardCreated decodes the creation timestamp embedded in a Trello object ID
+func cardCreated(id string) (time.Time, bool) {
+	if len(id) < 8 {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(id[:8], 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0).UTC(), true
+}
+
+func parseTrelloDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
+
+func withinDays(t, now time.Time, days int) bool {
+	return !t.After(now) && now.Sub(t) <= time.Duration(days)*24*time.Hour
+}
+
+func (n *queryTerm) match(card *TrelloCardSearchResult, ctx QueryContext) bool {
+	switch n.key {
+	case "":
+		return containsFold(card.Name, n.value) || containsFold(card.Desc, n.value)
+	case "name":
+		return containsFold(card.Name, n.value)
+	case "description":
+		return containsFold(card.Desc, n.value)
+	case "board":
+		return card.IDBoard == n.value || containsFold(ctx.BoardName(card), n.value)
+	case "list":
+		return card.IDList == n.value || containsFold(ctx.ListName(card), n.value)
+	case "label":
+		for _, label := range card.Labels {
+			if strings.ToLower(label.Name) == n.value || strings.ToLower(label.Color) == n.value || label.ID == n.value {
+				return true
+			}
+		}
+	case "member":
+		name := n.value
+		if name == "me" {
+			name = strings.ToLower(ctx.Me())
+		}
+		for _, id := range card.IDMembers {
+			if id == n.value {
+				return true
+			}
+		}
+		for _, member := range ctx.MemberNames(card) {
+			if strings.ToLower(member) == name {
+				return true
+			}
+		}
+	case "due":
+		due, ok := parseTrelloDate(card.Due)
+		switch n.value {
+		case "complete":
+			return ok && card.DueComplete
+		case "incomplete":
+			return ok && !card.DueComplete
+		case "overdue":
+			return ok && !card.DueComplete && due.Before(ctx.Now())
+		}
+		return ok && !due.Before(ctx.Now()) && due.Sub(ctx.Now()) <= time.Duration(n.days)*24*time.Hour
+	case "edited":
+		t, ok := parseTrelloDate(card.DateLastActivity)
+		return ok && withinDays(t, ctx.Now(), n.days)
+	case "created":
+		t, ok := cardCreated(card.ID)
+		return ok && withinDays(t, ctx.Now(), n.days)
+	case "is":
+		if n.value == "open" {
+			return !card.Closed
+		}
+		return card.Closed
+	case "has":
+		switch n.value {
+		case "attachments":
+			return card.Badges != nil && card.Badges.Attachments > 0
+		case "description":
+			return strings.TrimSpace(card.Desc) != ""
+		case "cover":
+			return card.IDAttachmentCover != ""
+		case "members":
+			return len(card.IDMembers) > 0
+		case "checklists":
+			return len(card.IDChecklists) > 0
+		}
+	}
+	return false
+}
+
+// Match reports whether the card satisfies the query.
+func (q *SearchQuery) Match(card *TrelloCardSearchResult, ctx QueryContext) bool {
+	return q.root.match(card, ctx)
+}
+
+// Filter returns the cards matching the query, at most limit cards if limit > 0.
+func (q *SearchQuery) Filter(cards []*TrelloCardSearchResult, ctx QueryContext, limit int) []*TrelloCardSearchResult {
+	result := []*TrelloCardSearchResult{}
+	for _, card := range cards {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		if q.Match(card, ctx) {
+			result = append(result, card)
+		}
+	}
+	return result
+}
+
+// localExport covers both a plain card list written by "--format json"
+// and a board exported from the Trello web interface.
+type localExport struct {
+	ID         string                    `json:"id"`
+	Name       string                    `json:"name"`
+	Cards      []*TrelloCardSearchResult `json:"cards"`
+	Lists      []*TrelloList             `json:"lists"`
+	Members    []*TrelloMember           `json:"members"`
+	Labels     []*TrelloLabel            `json:"labels"`
+	Checklists []*TrelloChecklist        `json:"checklists"`
+}
+
+// LoadLocalCards reads cards from a JSON file. Board names, list names and
+// members contained in a Trello board export are merged into the client
+// so that board:, list: and member: terms also work offline.
+func (client *TrelloClient) LoadLocalCards(filename string) ([]*TrelloCardSearchResult, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) > 0 && data[0] == '[' {
+		cards := []*TrelloCardSearchResult{}
+		err = json.Unmarshal(data, &cards)
+		return cards, err
+	}
+	export := localExport{}
+	if err = json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if export.ID != "" && NameFromID(export.ID, client.TrelloBoards) == "" {
+		client.TrelloBoards = append(client.TrelloBoards, &TrelloName{ID: export.ID, Name: export.Name})
+		boardName := strings.ToLower(export.Name)
+		for _, list := range export.Lists {
+			client.TrelloLists[boardName] = append(client.TrelloLists[boardName], &TrelloName{ID: list.IDList, Name: list.ListName})
+		}
+		dir := client.boardDirectory(export.ID)
+		dir.members, dir.labels, dir.checklists = export.Members, export.Labels, export.Checklists
+		dir.loaded["members"], dir.loaded["labels"], dir.loaded["checklists"] = true, true, true
+	}
+	return export.Cards, nil
+}
+
+func (client *TrelloClient) BoardName(card *TrelloCardSearchResult) string {
+	return NameFromID(card.IDBoard, client.TrelloBoards)
+}
+
+func (client *TrelloClient) ListName(card *TrelloCardSearchResult) string {
+	return NameFromID(card.IDList, client.TrelloLists[strings.ToLower(client.BoardName(card))])
+}
+
+func (client *TrelloClient) MemberNames(card *TrelloCardSearchResult) []string {
+	return client.memberUserNames(card.IDBoard, card.IDMembers)
+}
+
+func (client *TrelloClient) Me() string {
+	if client.me == nil {
+		client.me = &TrelloMember{}
+		if user := os.ExpandEnv("$TRELLO_USER"); user != "" && user != "me" {
+			client.me.UserName = user
+		} else if member, err := client.FetchMember("me"); err == nil {
+			client.me = member
+		}
+	}
+	return client.me.UserName
+}
+
+func (client *TrelloClient) Now() time.Time {
+	return time.Now()
+}
+
+// SearchLocalCards evaluates a search query against the cards of the file
+// given with --local instead of using the Trello search index.
+func (client *TrelloClient) SearchLocalCards(query string, limit int) ([]*TrelloCardSearchResult, error) {
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	cards, err := client.LoadLocalCards(client.config.LocalCards)
+	if err != nil {
+		return nil, err
+	}
+	return q.Filter(cards, client, limit), nil
+}
@@ -0,0 +1,127 @@
+package tres
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryContext answers the board, list and member questions of the local
+// query engine without a Trello client
+type queryContext struct{}
+
+func (queryContext) BoardName(card *TrelloCardSearchResult) string     { return "Team Board" }
+func (queryContext) ListName(card *TrelloCardSearchResult) string      { return card.IDList }
+func (queryContext) MemberNames(card *TrelloCardSearchResult) []string { return card.IDMembers }
+func (queryContext) Me() string                                        { return "fred" }
+func (queryContext) Now() time.Time {
+	return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+}
+
+var queryCards = []*TrelloCardSearchResult{
+	{Name: "Fix login bug", Desc: "fails on mobile", IDList: "Doing", IDMembers: []string{"fred"},
+		Labels: []*TrelloLabel{{Name: "bug", Color: "red"}}, Due: "2026-10-17T09:00:00.000Z"},
+	{Name: "Write docs", IDList: "Todo", Closed: true},
+	{Name: "Release 2.0", Desc: "after the login fix", IDList: "Todo", IDMembers: []string{"anna"},
+		Due: "2026-10-20T09:00:00.000Z", DueComplete: false},
+	{Name: "Login page redesign", IDList: "Done", IDMembers: []string{"anna", "fred"},
+		Labels: []*TrelloLabel{{Name: "design", Color: "blue"}}},
+}
+
+func TestSearchQueryMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string // names of the matching cards, comma separated
+	}{
+		{"login", "Fix login bug,Release 2.0,Login page redesign"},
+		{"name:login", "Fix login bug,Login page redesign"},
+		{`"login bug"`, "Fix login bug"},
+		{`name:"page redesign"`, "Login page redesign"},
+		{`list:"Todo"`, "Write docs,Release 2.0"},
+		{"-is:open", "Write docs"},
+		{"is:closed", "Write docs"},
+		{"-login", "Write docs"},
+		{"- login", ""}, // a lone - is a search word
+		{"--login", "Fix login bug,Release 2.0,Login page redesign"},
+		{"#bug", "Fix login bug"},
+		{"label:blue", "Login page redesign"},
+		{"@me", "Fix login bug,Login page redesign"},
+		{"due:overdue", "Fix login bug"},
+		{"due:week", "Release 2.0"},
+		// AND binds stronger than OR, with or without the AND
+		{"@anna login OR docs", "Write docs,Release 2.0,Login page redesign"},
+		{"@anna AND login OR docs", "Write docs,Release 2.0,Login page redesign"},
+		{"docs OR @anna login", "Write docs,Release 2.0,Login page redesign"},
+		{"@anna (login OR docs)", "Release 2.0,Login page redesign"},
+		{"-(login OR docs)", ""},
+		{"-login OR docs", "Write docs"},
+		{"login -#bug -@anna", ""},
+		{"login -#bug", "Release 2.0,Login page redesign"},
+		{"LOGIN or DOCS", "Fix login bug,Write docs,Release 2.0,Login page redesign"},
+	}
+	for _, test := range tests {
+		q, err := ParseSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		names := []string{}
+		for _, card := range q.Filter(queryCards, queryContext{}, 0) {
+			names = append(names, card.Name)
+		}
+		if got := strings.Join(names, ","); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSearchQueryLimit(t *testing.T) {
+	q, err := ParseSearchQuery("login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cards := q.Filter(queryCards, queryContext{}, 2); len(cards) != 2 {
+		t.Errorf("got %d cards, want 2", len(cards))
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"", 1, "empty query"},
+		{"   ", 4, "empty query"},
+		{`name:"login`, 6, "unterminated quoted phrase"},
+		{`bug "login`, 5, "unterminated quoted phrase"},
+		{"name: login", 1, "missing value for name:"},
+		{`board:"  "`, 7, "empty value for board:"},
+		{"is:nothing", 4, `invalid value "nothing" for is: (expected open, archived or closed)`},
+		{"has:due", 5, "invalid value"},
+		{"due:yesterday", 5, "invalid value"},
+		{"edited:overdue", 8, "invalid value"},
+		{"comment:done", 1, "comment: is not supported"},
+		{"(login", 1, "missing ')' for this '('"},
+		{"bug (login OR docs", 5, "missing ')' for this '('"},
+		{"login)", 6, "unexpected ')'"},
+		{"()", 2, "empty parentheses"},
+		{"login OR", 7, "expected search term after OR"},
+		{"OR login", 1, "unexpected OR"},
+		{"login AND OR docs", 7, "expected search term after AND"},
+		{"login AND", 7, "expected search term after AND"},
+		{"bug -OR docs", 5, "expected search term after '-'"},
+		{"bug -)", 6, "unexpected ')'"}, // a lone - is a search word
+	}
+	for _, test := range tests {
+		_, err := ParseSearchQuery(test.query)
+		syntaxErr, ok := err.(*QuerySyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a QuerySyntaxError", test.query, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos || !strings.Contains(syntaxErr.Msg, test.msg) {
+			t.Errorf("%q: got %d %q, want %d %q", test.query, syntaxErr.Pos, syntaxErr.Msg, test.pos, test.msg)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tealeg/xlsx"
+)
+
+// reportSection is a section of a query file with the cards it found
+type reportSection struct {
+	*querySection
+	Groups []*CardGroup
+}
+
+// reportTitle is the name of a query file without the extension
+func reportTitle(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+}
+
+// inSection runs f with the settings of a section
+func (client *TrelloClient) inSection(section *querySection, f func() error) error {
+	config := client.config
+	client.config = section.Config
+	defer func() { client.config = config }()
+	return f()
+}
+
+// runSections searches the cards of every section with its own settings
+func (client *TrelloClient) runSections(sections []*querySection) ([]*reportSection, error) {
+	report := []*reportSection{}
+	for _, section := range sections {
+		var groups []*CardGroup
+		err := client.inSection(section, func() error {
+			cards, err := client.searchQuery(section.Query)
+			if err == nil {
+				groups, err = client.prepareCards(cards)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Section %s: %w", section.Title, err)
+		}
+		report = append(report, &reportSection{section, groups})
+	}
+	return report, nil
+}
+
+// reportFormat checks that a format can write a report with sections
+func (client *TrelloClient) reportFormat(format string) (string, error) {
+	format = strings.ToLower(format)
+	switch format {
+	case "text", "kanban", "markdown", "html", "json", "excel":
+	case "csv", "ical", "svg":
+		return "", errors.New("Format not supported for this operation.")
+	default:
+		return "", errors.New("INVALID_OUTPUT_FORMAT")
+	}
+	if client.config.Aggregate {
+		return "", errors.New("Statistics are not supported for query files with @section")
+	}
+	return format, nil
+}
+
+// outputReport runs the sections of a query file and writes them as one
+// document: headed sections in text, markdown and html, a sheet per section
+// in excel and an object with the section titles as keys in json.
+func (client *TrelloClient) outputReport(title string, sections []*querySection, format string) error {
+	format, err := client.reportFormat(format)
+	if err != nil {
+		return err
+	}
+	report, err := client.runSections(sections)
+	if err != nil {
+		return err
+	}
+	return client.writeReport(title, report, format)
+}
+
+// writeReport writes the sections found by runSections
+func (client *TrelloClient) writeReport(title string, report []*reportSection, format string) error {
+	var err error
+	switch format {
+	case "text", "kanban":
+		err = client.reportText(report, format)
+	case "markdown":
+		err = client.reportMarkdown(report)
+	case "html":
+		err = client.reportHTML(title, report)
+	case "json":
+		err = client.reportJSON(report)
+	case "excel":
+		err = client.reportExcel(report)
+	}
+	return err
+}
+
+func (client *TrelloClient) reportText(report []*reportSection, format string) error {
+	for i, section := range report {
+		if i > 0 {
+			fmt.Fprintln(client.out)
+		}
+		fmt.Fprintln(client.out, section.Title)
+		fmt.Fprintln(client.out, strings.Repeat("=", utf8.RuneCountInString(section.Title)))
+		fmt.Fprintln(client.out)
+		err := client.inSection(section.querySection, func() error {
+			if format == "kanban" {
+				return client.formatterKanban(section.Groups)
+			}
+			return client.formatterText(section.Groups)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportMarkdown writes every section under a first level heading, the
+// headings of the cards move one level down
+func (client *TrelloClient) reportMarkdown(report []*reportSection) error {
+	for _, section := range report {
+		fmt.Fprint(client.out, "# "+section.Title+"\n\n")
+		err := client.inSection(section.querySection, func() error {
+			return client.formatterMarkdown(section.Groups, 2)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportHTML makes every section a heading with the groups or lists of its
+// cards below
+func (client *TrelloClient) reportHTML(title string, report []*reportSection) error {
+	sections := []*htmlSection{}
+	count := 0
+	for _, section := range report {
+		s := &htmlSection{Name: section.Title}
+		client.inSection(section.querySection, func() error {
+			parts := client.htmlSections(section.Groups)
+			for _, part := range parts {
+				if part.Sections == nil {
+					s.Sections = append(s.Sections, part) // a group of --group-by
+					continue
+				}
+				for _, list := range part.Sections {
+					if len(parts) > 1 {
+						list.Name = part.Name + " / " + list.Name
+					}
+					s.Sections = append(s.Sections, list)
+				}
+			}
+			return nil
+		})
+		for _, group := range section.Groups {
+			count += len(group.Cards)
+		}
+		sections = append(sections, s)
+	}
+	return client.writeHTML(title, count, sections)
+}
+
+// reportJSON writes an object with the section titles as keys, in the order
+// of the sections
+func (client *TrelloClient) reportJSON(report []*reportSection) error {
+	members := []string{}
+	for _, section := range report {
+		var cards interface{}
+		client.inSection(section.querySection, func() error {
+			cards = client.jsonCards(section.Groups)
+			return nil
+		})
+		key, err := json.Marshal(section.Title)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(cards)
+		if err != nil {
+			return err
+		}
+		members = append(members, string(key)+":"+string(value))
+	}
+	fmt.Fprint(client.out, "{"+strings.Join(members, ",")+"}")
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+// reportExcel writes a sheet per section, grouped cards are written one
+// group after the other
+func (client *TrelloClient) reportExcel(report []*reportSection) error {
+	file := xlsx.NewFile()
+	used := map[string]bool{}
+	for _, section := range report {
+		section.Config.QuoteChar = "" // we do not need quoting in excel
+		err := client.inSection(section.querySection, func() error {
+			cards := []*TrelloCardSearchResult{}
+			for _, group := range section.Groups {
+				cards = append(cards, group.Cards...)
+			}
+			return client.addCardSheet(file, section.Title, cards, used)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return file.Write(client.out)
+}
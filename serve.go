@@ -0,0 +1,318 @@
+package tres
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// query file extension and the name of an endpoint
+const queryFileExt = ".trs"
+
+// maxCacheEntries limits the cache, every query string is an entry
+const maxCacheEntries = 256
+
+var endpointPattern = regexp.MustCompile(`^/([A-Za-z0-9_-]+)(\.[a-z]+)?$`)
+
+// output formats by URL extension and by MIME type in the Accept header
+var (
+	formatByExt = map[string]string{
+		".json": "json",
+		".csv":  "csv",
+		".xlsx": "excel",
+		".md":   "markdown",
+		".html": "html",
+		".txt":  "text",
+	}
+	formatByMIME = map[string]string{
+		"application/json": "json",
+		"text/csv":         "csv",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "excel",
+		"text/markdown": "markdown",
+		"text/html":     "html",
+		"text/plain":    "text",
+	}
+	contentTypes = map[string]string{
+		"json":     "application/json",
+		"csv":      "text/csv; charset=utf-8",
+		"excel":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"markdown": "text/markdown; charset=utf-8",
+		"html":     "text/html; charset=utf-8",
+		"text":     "text/plain; charset=utf-8",
+	}
+)
+
+type cachedResponse struct {
+	created     time.Time
+	contentType string
+	body        []byte
+}
+
+// queryServer serves the query files of a directory. Requests are handled
+// one at a time, every request runs on a copy of the client config so the
+// @-commands of one query file do not leak into the next.
+type queryServer struct {
+	client   *TrelloClient
+	dir      string
+	user     string
+	password string
+	maxAge   time.Duration
+	mutex    sync.Mutex
+	cache    map[string]*cachedResponse
+}
+
+// requestFormat picks the format from the URL extension or the Accept
+// header, "" means the format of the query file
+func requestFormat(ext string, r *http.Request) (string, bool) {
+	if ext != "" {
+		format, ok := formatByExt[ext]
+		return format, ok
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime := strings.TrimSpace(strings.Split(accept, ";")[0])
+		if format, ok := formatByMIME[mime]; ok {
+			return format, true
+		}
+	}
+	return "", true
+}
+
+func (s *queryServer) authorized(r *http.Request) bool {
+	if s.user == "" {
+		return true
+	}
+	user, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+func (s *queryServer) queryNames() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), queryFileExt)
+		// files like "my report.trs" have no endpoint
+		if !file.IsDir() && strings.HasSuffix(file.Name(), queryFileExt) && endpointPattern.MatchString("/"+name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *queryServer) index(w http.ResponseWriter) {
+	names, err := s.queryNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>tres queries</title></head><body>\n<h1>Queries</h1>\n<ul>\n"
+	for _, name := range names {
+		href := html.EscapeString(url.PathEscape(name))
+		page += "<li><a href=\"" + href + ".html\">" + html.EscapeString(name) + "</a>"
+		for _, ext := range []string{".json", ".csv", ".xlsx", ".md"} {
+			page += " <a href=\"" + href + ext + "\">" + ext[1:] + "</a>"
+		}
+		page += "</li>\n"
+	}
+	page += "</ul>\n</body></html>\n"
+	w.Header().Set("Content-Type", contentTypes["html"])
+	w.Write([]byte(page))
+}
+
+// searchStatus is the HTTP status of a failed search, malformed queries are
+// the fault of the request, everything else of Trello or the network
+func searchStatus(err error) int {
+	var querySyntax *QuerySyntaxError
+	var whereSyntax *WhereSyntaxError
+	if errors.As(err, &querySyntax) || errors.As(err, &whereSyntax) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// run executes a query file with the request parameters as variables. If it
+// fails it returns the HTTP status for the error.
+func (s *queryServer) run(filename, format string, params map[string][]string) (*cachedResponse, int, error) {
+	config := *s.client.config
+	client := *s.client
+	client.config = &config
+	client.vars = map[string]string{}
+	for name, value := range s.client.vars {
+		client.vars[name] = value
+	}
+	for name, values := range params {
+		client.vars[name] = values[0]
+	}
+	buf := &bytes.Buffer{}
+	client.out = buf
+
+	query, sections, err := client.loadQuery(filename)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Could not load query: " + err.Error())
+	}
+	if format != "" {
+		config.Format = format // the request wins over @format
+	}
+	format = strings.ToLower(config.Format)
+	// statistics have no html format, their text output is wrapped in a page
+	htmlPage := format == "html" && config.Aggregate && sections == nil
+	if htmlPage {
+		config.Format = "text"
+	}
+	var cards []*TrelloCardSearchResult
+	var report []*reportSection
+	if sections != nil {
+		if _, err = client.reportFormat(config.Format); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		report, err = client.runSections(sections)
+	} else {
+		cards, err = client.searchQuery(query)
+	}
+	if err != nil {
+		return nil, searchStatus(err), err
+	}
+	if sections != nil {
+		err = client.writeReport(reportTitle(filename), report, format)
+	} else if config.Aggregate {
+		err = client.outputStats(cards, config.Format)
+	} else {
+		err = client.outputCards(cards, config.Format)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	body := buf.Bytes()
+	if htmlPage {
+		title := html.EscapeString(strings.TrimSuffix(filepath.Base(filename), queryFileExt))
+		body = []byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>" + title + "</title></head><body>\n<h1>" +
+			title + "</h1>\n<pre>" + html.EscapeString(string(body)) + "</pre>\n</body></html>\n")
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return &cachedResponse{time.Now(), contentType, body}, 0, nil
+}
+
+// store adds a response to the cache after dropping the expired ones. If it
+// is still full the oldest response goes.
+func (s *queryServer) store(key string, response *cachedResponse) {
+	oldest := ""
+	for k, cached := range s.cache {
+		if time.Since(cached.created) > s.maxAge {
+			delete(s.cache, k)
+		} else if oldest == "" || cached.created.Before(s.cache[oldest].created) {
+			oldest = k
+		}
+	}
+	if _, ok := s.cache[key]; !ok && len(s.cache) >= maxCacheEntries {
+		delete(s.cache, oldest)
+	}
+	s.cache[key] = response
+}
+
+func (s *queryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="tres"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/" {
+		s.index(w)
+		return
+	}
+	m := endpointPattern.FindStringSubmatch(r.URL.Path)
+	filename := ""
+	if m != nil {
+		filename = filepath.Join(s.dir, m[1]+queryFileExt)
+	}
+	if m == nil || !isFile(filename) {
+		http.NotFound(w, r)
+		return
+	}
+	format, ok := requestFormat(m[2], r)
+	if !ok {
+		http.Error(w, "unknown format "+m[2], http.StatusNotFound)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	params := r.URL.Query()
+	key := m[1] + "|" + format + "|" + params.Encode()
+	response, ok := s.cache[key]
+	if !ok || time.Since(response.created) > s.maxAge {
+		var status int
+		var err error
+		if response, status, err = s.run(filename, format, params); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if s.maxAge > 0 {
+			s.store(key, response)
+		}
+	}
+	w.Header().Set("Content-Type", response.contentType)
+	if response.contentType == contentTypes["excel"] {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+m[1]+`.xlsx"`)
+	}
+	w.Write(response.body)
+}
+
+// Serve makes every query file in the --queries directory available as
+// http://<listen>/<name>.<json|csv|xlsx|md|html|txt>.
+func (client *TrelloClient) Serve() error {
+	if client.config.Queries == "" {
+		return errors.New("Missing --queries directory")
+	}
+	if fi, err := os.Stat(client.config.Queries); err != nil || !fi.IsDir() {
+		return errors.New("Not a directory: " + client.config.Queries)
+	}
+	maxAge, err := time.ParseDuration(client.config.CacheTime)
+	if err != nil {
+		return errors.New("Invalid --cache '" + client.config.CacheTime + "', use e.g. 0, 30s or 5m")
+	}
+	server := &queryServer{
+		client: client,
+		dir:    client.config.Queries,
+		maxAge: maxAge,
+		cache:  make(map[string]*cachedResponse),
+	}
+	auth := client.config.Auth
+	if auth == "" {
+		auth = os.ExpandEnv("$TRES_AUTH")
+	}
+	if auth != "" {
+		i := strings.Index(auth, ":")
+		if i < 1 {
+			return errors.New("Invalid --auth, use user:password")
+		}
+		server.user, server.password = auth[:i], auth[i+1:]
+	} else {
+		fmt.Fprintln(os.Stderr, "Warning: no --auth given, everybody who can reach the server can read your cards")
+	}
+	fmt.Fprintln(os.Stderr, "Serving queries from "+server.dir+" on "+client.config.Listen)
+	return http.ListenAndServe(client.config.Listen, server)
+}
@@ -0,0 +1,99 @@
+package tres
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryServerCache(t *testing.T) {
+	s := &queryServer{maxAge: time.Minute, cache: make(map[string]*cachedResponse)}
+	s.cache["expired"] = &cachedResponse{created: time.Now().Add(-time.Hour)}
+	s.store("fresh", &cachedResponse{created: time.Now()})
+	if _, ok := s.cache["expired"]; ok {
+		t.Error("expired response was kept")
+	}
+
+	start := time.Now().Add(-time.Second * maxCacheEntries)
+	for i := 0; i < maxCacheEntries+10; i++ {
+		s.store("q"+strconv.Itoa(i), &cachedResponse{created: start.Add(time.Duration(i) * time.Second)})
+	}
+	if len(s.cache) > maxCacheEntries {
+		t.Errorf("cache has %d entries, at most %d allowed", len(s.cache), maxCacheEntries)
+	}
+	if _, ok := s.cache["q"+strconv.Itoa(maxCacheEntries+9)]; !ok {
+		t.Error("newest response is missing")
+	}
+	if _, ok := s.cache["q0"]; ok {
+		t.Error("oldest response was kept")
+	}
+}
+
+func TestQueryServerIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"open-bugs.trs", "my report.trs", "notes.txt"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("is:open\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &queryServer{dir: dir}
+	w := httptest.NewRecorder()
+	s.index(w)
+	page := w.Body.String()
+	if !strings.Contains(page, `<a href="open-bugs.html">open-bugs</a>`) {
+		t.Errorf("open-bugs is not listed:\n%s", page)
+	}
+	if strings.Contains(page, "my report") || strings.Contains(page, "notes") {
+		t.Errorf("files without an endpoint are listed:\n%s", page)
+	}
+}
+
+func TestQueryServerErrorStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queries := map[string]string{
+		"open.trs":   "is:open\n",
+		"param.trs":  "@param owner\n@${owner}\n",
+		"where.trs":  "is:open\n@where due >\n",
+		"broken.trs": "@section \"Open\"\nis:open\n@section \"Open\"\n",
+	}
+	for name, query := range queries {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(query), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path   string
+		search string // the search result, none makes Trello fail
+		status int
+	}{
+		{"/open.json", "", 502},
+		{"/param.json", "", 400}, // missing parameter
+		{"/where.json", `{"cards": []}`, 400},
+		{"/broken.json", "", 400}, // duplicate section
+	}
+	for _, test := range tests {
+		f := &fakeTrello{responses: map[string]string{}}
+		if test.search != "" {
+			f.responses["GET /1/search"] = test.search
+		}
+		s := &queryServer{client: newFakeClient(f), dir: dir, cache: make(map[string]*cachedResponse)}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.path, w.Code, test.status, w.Body.String())
+		}
+	}
+}
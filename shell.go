@@ -0,0 +1,518 @@
+package tres
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// the at-commands of query files, see handleAtCommand
+var atCommands = []string{"@fields", "@format", "@colsep", "@rowsep", "@limit", "@sort", "@groupby", "@where", "@param"}
+
+var shellCommands = []string{":help", ":quit", ":reload", ":save", ":show"}
+
+var outputFormats = []string{"text", "excel", "csv", "json", "markdown", "html", "kanban", "ical"}
+
+// search operators tab completion offers, names are completed after
+// board:, list:, label:, #, member: and @
+var searchOperators = []string{
+	"board:", "list:", "label:", "member:", "name:", "desc:", "comment:", "checklist:",
+	"is:open", "is:archived", "is:starred", "has:attachments", "has:cover", "has:description", "has:members",
+	"due:day", "due:week", "due:month", "due:overdue", "due:complete", "due:incomplete",
+	"created:", "edited:", "sort:created", "sort:edited", "sort:due",
+}
+
+const shellHelp = `Enter a search query like on the command line, without single quotes, or
+the name of a query file. Lines starting with @ change the output like in a
+query file, e.g. @format csv; an at-command alone shows its value.
+@param <name> <value> sets a variable for ${name}, @param alone lists them.
+
+    :show           the at-commands of this session and the last query
+    :save <file>    save them as a query file (.trs is added if missing)
+    :reload         read boards, lists, labels and members again
+    :quit           leave the shell, Ctrl-D works as well
+
+Tab completes board, list, label (also after #) and member names, search operators and
+at-commands, the up and down keys go through the history.`
+
+const historySize = 500
+
+// lineEditor reads lines from a terminal in raw mode, with history and tab
+// completion
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string
+	complete func(before string) (int, []string)
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > historySize {
+		e.history = e.history[len(e.history)-historySize:]
+	}
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	line := []rune{}
+	pos := 0
+	hist := len(e.history)
+	edited := "" // the new line while going through the history
+	redraw := func() {
+		fmt.Fprint(e.out, "\r\x1b[K"+prompt+string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	redraw()
+	for {
+		key, err := readKey(e.in)
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "enter":
+			fmt.Fprint(e.out, "\r\n")
+			e.addHistory(string(line))
+			return string(line), nil
+		case "ctrl-c":
+			fmt.Fprint(e.out, "^C\r\n")
+			line, pos, hist = []rune{}, 0, len(e.history)
+		case "ctrl-d":
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			fallthrough
+		case "delete":
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case "backspace":
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case "left":
+			if pos > 0 {
+				pos--
+			}
+		case "right":
+			if pos < len(line) {
+				pos++
+			}
+		case "home":
+			pos = 0
+		case "end":
+			pos = len(line)
+		case "ctrl-u":
+			line, pos = line[pos:], 0
+		case "up":
+			if hist > 0 {
+				if hist == len(e.history) {
+					edited = string(line)
+				}
+				hist--
+				line = []rune(e.history[hist])
+				pos = len(line)
+			}
+		case "down":
+			if hist < len(e.history) {
+				hist++
+				if hist == len(e.history) {
+					line = []rune(edited)
+				} else {
+					line = []rune(e.history[hist])
+				}
+				pos = len(line)
+			}
+		case "tab":
+			line, pos = e.completeLine(line, pos)
+		default:
+			if r := []rune(key); len(r) == 1 && r[0] >= ' ' {
+				line = append(line[:pos], append(r, line[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// commonPrefix is the longest prefix of all words, ignoring case
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+	for _, word := range words[1:] {
+		r := []rune(word)
+		n := 0
+		for n < len(prefix) && n < len(r) && strings.EqualFold(string(prefix[n]), string(r[n])) {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+// completeLine completes the word before the cursor. A single candidate
+// is inserted, of several the common part, or they are listed if there is
+// nothing to add.
+func (e *lineEditor) completeLine(line []rune, pos int) ([]rune, int) {
+	before := string(line[:pos])
+	start, candidates := e.complete(before)
+	if len(candidates) == 0 {
+		return line, pos
+	}
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(completion, ":") {
+		completion += " "
+	}
+	if len([]rune(completion)) <= len([]rune(before[start:])) {
+		fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+		return line, pos
+	}
+	before = before[:start] + completion
+	return []rune(before + string(line[pos:])), len([]rune(before))
+}
+
+type shell struct {
+	client   *TrelloClient
+	settings []string // the last at-command of each kind in this session
+	query    string
+}
+
+var boardTerm = regexp.MustCompile(`(?i)board:("[^"]*"|\S+)`)
+
+// queryBoards are the boards named in the query, all boards if none is
+func (sh *shell) queryBoards(query string) []string {
+	ids := []string{}
+	for _, m := range boardTerm.FindAllStringSubmatch(query, -1) {
+		if id, _, err := sh.client.boardFromName(strings.Trim(m[1], `"`)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		for _, board := range sh.client.TrelloBoards {
+			ids = append(ids, board.ID)
+		}
+	}
+	return ids
+}
+
+// complete returns where the word before the cursor starts and the words
+// that could replace it
+func (sh *shell) complete(before string) (int, []string) {
+	start, quoted := 0, false
+	for i, r := range before {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			start = i + 1
+		}
+	}
+	word := before[start:]
+	lower := strings.ToLower(word)
+	command := ""
+	if fields := strings.Fields(before); len(fields) > 0 {
+		command = strings.ToLower(fields[0])
+	}
+
+	prefix := ""
+	names := []string{}
+	switch {
+	case start == 0 && strings.HasPrefix(word, "@"):
+		names = atCommands
+	case start == 0 && strings.HasPrefix(word, ":"):
+		names = shellCommands
+	case command == "@format":
+		names = outputFormats
+	case command == "@groupby":
+		names = groupDimensions
+	case strings.HasPrefix(lower, "board:"):
+		prefix = word[:6]
+		for _, board := range sh.client.TrelloBoards {
+			names = append(names, board.Name)
+		}
+	case strings.HasPrefix(lower, "list:"):
+		prefix = word[:5]
+		for _, lists := range sh.client.TrelloLists {
+			for _, list := range lists {
+				names = append(names, list.Name)
+			}
+		}
+	case strings.HasPrefix(lower, "label:") || strings.HasPrefix(word, "#"):
+		prefix = word[:strings.IndexAny(word, ":#")+1]
+		for _, boardID := range sh.queryBoards(before) {
+			for _, label := range sh.client.directoryLabels(boardID) {
+				if label.Name != "" {
+					names = append(names, label.Name)
+				}
+			}
+		}
+	case strings.HasPrefix(lower, "member:") || strings.HasPrefix(word, "@"):
+		prefix = word[:strings.IndexAny(word, ":@")+1]
+		for _, boardID := range sh.queryBoards(before) {
+			for _, member := range sh.client.directoryMembers(boardID) {
+				names = append(names, member.UserName)
+			}
+		}
+	default:
+		names = searchOperators
+	}
+
+	seen := map[string]bool{}
+	candidates := []string{}
+	typed := strings.Replace(lower, `"`, "", -1)
+	for _, name := range names {
+		if strings.Contains(name, " ") {
+			name = `"` + name + `"`
+		}
+		candidate := prefix + name
+		if !seen[candidate] && strings.HasPrefix(strings.Replace(strings.ToLower(candidate), `"`, "", -1), typed) {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Strings(candidates)
+	return start, candidates
+}
+
+// atCommand changes a setting, without parameters it shows the value
+func (sh *shell) atCommand(line string) error {
+	fields := strings.Fields(line)
+	cmd := strings.ToLower(fields[0])
+	known := false
+	for _, c := range atCommands {
+		known = known || c == cmd
+	}
+	if !known {
+		return errors.New("Unknown at-command " + fields[0] + ", use one of " + strings.Join(atCommands, " "))
+	}
+	if cmd == "@param" {
+		return sh.param(line, fields)
+	}
+	if len(fields) == 1 {
+		config := sh.client.config
+		values := map[string]string{
+			"@fields":  config.SearchResultFields,
+			"@format":  config.Format,
+			"@colsep":  config.ColSep,
+			"@rowsep":  config.RowSep,
+			"@limit":   fmt.Sprint(config.CardLimit),
+			"@sort":    config.SortFields,
+			"@groupby": config.GroupBy,
+			"@where":   config.Where,
+		}
+		fmt.Fprintf(sh.client.out, "%s %q\n", cmd, values[cmd])
+		return nil
+	}
+	if err := sh.client.handleAtCommand(line); err != nil {
+		return err
+	}
+	settings := []string{}
+	for _, s := range sh.settings {
+		if strings.ToLower(strings.Fields(s)[0]) != cmd {
+			settings = append(settings, s)
+		}
+	}
+	sh.settings = append(settings, line)
+	return nil
+}
+
+// param sets a variable for the rest of the session, unlike in a query file
+// it replaces the value. Without a value it shows the variable, alone all
+// variables.
+func (sh *shell) param(line string, fields []string) error {
+	vars := sh.client.vars
+	switch len(fields) {
+	case 1:
+		names := []string{}
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(sh.client.out, "@param %s %q\n", name, vars[name])
+		}
+		return nil
+	case 2:
+		value, ok := vars[fields[1]]
+		if !ok {
+			return errors.New("Undefined variable ${" + fields[1] + "}")
+		}
+		fmt.Fprintf(sh.client.out, "@param %s %q\n", fields[1], value)
+		return nil
+	}
+	delete(vars, fields[1])
+	if err := sh.client.declareParam(strings.TrimSpace(line[len(fields[0]):])); err != nil {
+		return err
+	}
+	settings := []string{}
+	for _, s := range sh.settings {
+		f := strings.Fields(s)
+		if strings.ToLower(f[0]) != "@param" || len(f) < 2 || f[1] != fields[1] {
+			settings = append(settings, s)
+		}
+	}
+	sh.settings = append(settings, line)
+	return nil
+}
+
+// session is the query file for :show and :save
+func (sh *shell) session() string {
+	s := "// saved from tres shell on " + sh.client.Now().Format("2006-01-02 15:04") + "\n\n"
+	for _, setting := range sh.settings {
+		s += setting + "\n"
+	}
+	if sh.query != "" {
+		s += "\n" + sh.query + "\n"
+	}
+	return s
+}
+
+func (sh *shell) shellCommand(line string) (bool, error) {
+	fields := strings.Fields(line)
+	switch strings.ToLower(fields[0]) {
+	case ":quit", ":q", ":exit":
+		return true, nil
+	case ":help":
+		fmt.Fprintln(sh.client.out, shellHelp)
+	case ":show":
+		fmt.Fprint(sh.client.out, sh.session())
+	case ":save":
+		if len(fields) < 2 {
+			return false, errors.New("Missing file name, use :save <file>")
+		}
+		filename := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		if filepath.Ext(filename) == "" {
+			filename += queryFileExt
+		}
+		if err := ioutil.WriteFile(filename, []byte(sh.session()), 0644); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(sh.client.out, "Saved "+filename)
+	case ":reload":
+		sh.client.directories = make(map[string]*boardDirectory)
+		return false, sh.client.FetchBoardInfo()
+	default:
+		return false, errors.New("Unknown command " + fields[0] + ", use one of " + strings.Join(shellCommands, " "))
+	}
+	return false, nil
+}
+
+// execute runs a line of the shell and returns true to quit
+func (sh *shell) execute(line string) (bool, error) {
+	line, err := sh.client.expandVars(stripComment(strings.TrimSpace(line)))
+	switch {
+	case err != nil || line == "":
+		return false, err
+	case line[0] == ':':
+		return sh.shellCommand(line)
+	case line[0] == '@':
+		return false, sh.atCommand(line)
+	}
+	query := line
+	if isFile(line) {
+		// the at-commands of a query file only apply to that query
+		config := sh.client.config
+		copied := *config
+		sh.client.config = &copied
+		defer func() { sh.client.config = config }()
+		// :save writes the query, not the name of the file
+		data, err := ioutil.ReadFile(line)
+		if err != nil {
+			return false, err
+		}
+		query = strings.TrimSpace(string(data))
+	}
+	cards, sections, err := sh.client.findReport(line)
+	if err != nil {
+		return false, err
+	}
+	sh.query = query
+	if sections != nil {
+		return false, sh.client.outputReport(reportTitle(line), sections, sh.client.config.Format)
+	}
+	if sh.client.config.Aggregate {
+		return false, sh.client.outputStats(cards, sh.client.config.Format)
+	}
+	return false, sh.client.outputCards(cards, sh.client.config.Format)
+}
+
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".tres_history")
+}
+
+// Shell reads search queries, at-commands and shell commands until :quit
+// or the end of the input. Boards and lists are only read once, at start.
+// With a terminal it has a line editor with history and tab completion.
+func (client *TrelloClient) Shell() error {
+	sh := &shell{client: client}
+	interactive := isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	if interactive {
+		// the terminal may not support raw mode on this platform
+		restore, err := makeRaw(os.Stdin)
+		if interactive = err == nil; interactive {
+			restore()
+		}
+	}
+	if !interactive {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			quit, err := sh.execute(scanner.Text())
+			if err != nil {
+				fmt.Fprintln(client.out, err.Error())
+			}
+			if quit {
+				break
+			}
+		}
+		return scanner.Err()
+	}
+
+	editor := &lineEditor{in: bufio.NewReader(os.Stdin), out: os.Stdout, complete: sh.complete}
+	if data, err := ioutil.ReadFile(historyFile()); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			editor.addHistory(line)
+		}
+	}
+	fmt.Fprintln(client.out, "tres shell, :help for help, :quit or Ctrl-D to leave")
+	for {
+		restore, err := makeRaw(os.Stdin)
+		if err != nil {
+			return err
+		}
+		line, err := editor.readLine("tres> ")
+		restore()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		quit, err := sh.execute(line)
+		if err != nil {
+			fmt.Fprintln(client.out, err.Error())
+		}
+		if quit {
+			break
+		}
+	}
+	if filename := historyFile(); filename != "" {
+		ioutil.WriteFile(filename, []byte(strings.Join(editor.history, "\n")+"\n"), 0600)
+	}
+	return nil
+}
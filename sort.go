@@ -0,0 +1,236 @@
+package tres
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// CardGroup is a named set of cards, the result of --group-by.
+// Without grouping there is exactly one group with an empty name.
+type CardGroup struct {
+	Name  string
+	Cards []*TrelloCardSearchResult
+}
+
+// group names for cards without a value for the grouping dimension
+const (
+	noGroupValue = "(none)"
+	noDueDate    = "(no due date)"
+)
+
+var groupDimensions = []string{"boardname", "listname", "label", "member", "due"}
+
+func parseSortKeys(spec string) ([]sortKey, error) {
+	keys := []sortKey{}
+	for _, v := range strings.Split(spec, ",") {
+		v = strings.TrimSpace(strings.ToLower(v))
+		if v == "" {
+			continue
+		}
+		key := sortKey{field: v}
+		// the last colon separates the direction, custom fields contain one as well
+		if i := strings.LastIndex(v, ":"); i >= 0 && !(isCustomField(v) && i == len(customFieldPrefix)-1) {
+			key.field = strings.TrimSpace(v[:i])
+			switch strings.TrimSpace(v[i+1:]) {
+			case "asc":
+			case "desc":
+				key.desc = true
+			default:
+				if !isCustomField(v) {
+					return nil, errors.New("Invalid sort direction in '" + v + "', use asc or desc")
+				}
+				key.field = v // a custom field name with a colon
+			}
+		}
+		if key.field == "" {
+			return nil, errors.New("Missing field name in sort specification '" + spec + "'")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortValue is the field value used for comparisons, pos needs more precision
+// than the output format gives us and checkedratio is written with a percent
+// sign
+func (client *TrelloClient) sortValue(card *TrelloCardSearchResult, field string) string {
+	switch field {
+	case "pos":
+		return strconv.FormatFloat(card.Pos, 'f', -1, 64)
+	case "checkedratio":
+		if card.Badges.CheckItems == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(float64(card.Badges.CheckItemsChecked)/float64(card.Badges.CheckItems), 'f', -1, 64)
+	}
+	return client.fieldValue(card, field)
+}
+
+// compareValues compares numerically if both values are numbers, otherwise
+// case-insensitive. Empty values always sort last.
+func compareValues(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func (client *TrelloClient) sortCards(cards []*TrelloCardSearchResult) error {
+	if strings.TrimSpace(client.config.SortFields) == "" {
+		return nil
+	}
+	keys, err := parseSortKeys(client.config.SortFields)
+	if err != nil {
+		return err
+	}
+	// fetch every value once, some fields need API calls
+	values := make(map[*TrelloCardSearchResult][]string, len(cards))
+	for _, card := range cards {
+		row := make([]string, len(keys))
+		for i, key := range keys {
+			row[i] = client.sortValue(card, key.field)
+		}
+		values[card] = row
+	}
+	sort.SliceStable(cards, func(i, j int) bool {
+		a, b := values[cards[i]], values[cards[j]]
+		for k, key := range keys {
+			c := compareValues(a[k], b[k])
+			if c == 0 {
+				continue
+			}
+			if key.desc && a[k] != "" && b[k] != "" {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+func (client *TrelloClient) groupNames(card *TrelloCardSearchResult, dimension string) []string {
+	names := []string{}
+	switch dimension {
+	case "boardname":
+		names = append(names, client.BoardName(card))
+	case "listname":
+		names = append(names, client.ListName(card))
+	case "label":
+		for _, label := range card.Labels {
+			s := label.Name
+			if s == "" {
+				s = "[" + strings.ToUpper(label.Color) + "]"
+			}
+			names = append(names, s)
+		}
+	case "member":
+		names = client.MemberNames(card)
+	case "due":
+		if len(card.Due) >= 10 {
+			names = append(names, card.Due[:10])
+		} else {
+			names = append(names, noDueDate)
+		}
+	}
+	if len(names) == 0 || names[0] == "" {
+		names = []string{noGroupValue}
+	}
+	return names
+}
+
+// groupCards splits the cards by the --group-by dimension. Cards with several
+// labels or members show up in each of their groups.
+func (client *TrelloClient) groupCards(cards []*TrelloCardSearchResult) ([]*CardGroup, error) {
+	dimension := strings.TrimSpace(strings.ToLower(client.config.GroupBy))
+	if dimension == "" {
+		return []*CardGroup{{Cards: cards}}, nil
+	}
+	valid := false
+	for _, v := range groupDimensions {
+		valid = valid || v == dimension
+	}
+	if !valid {
+		return nil, errors.New("Invalid group-by field '" + dimension + "', use one of " + strings.Join(groupDimensions, "|"))
+	}
+
+	groups := []*CardGroup{}
+	index := map[string]*CardGroup{}
+	for _, card := range cards {
+		for _, name := range client.groupNames(card, dimension) {
+			group, ok := index[name]
+			if !ok {
+				group = &CardGroup{Name: name}
+				index[name] = group
+				groups = append(groups, group)
+			}
+			group.Cards = append(group.Cards, card)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Name, groups[j].Name
+		if a == noGroupValue || a == noDueDate {
+			return false
+		}
+		if b == noGroupValue || b == noDueDate {
+			return true
+		}
+		return strings.ToLower(a) < strings.ToLower(b)
+	})
+	return groups, nil
+}
+
+func (client *TrelloClient) isGrouped() bool {
+	return strings.TrimSpace(client.config.GroupBy) != ""
+}
+
+// sheetName turns a group name into a valid and unique Excel sheet name
+func sheetName(name string, used map[string]bool) string {
+	if name == "" {
+		name = "Sheet1"
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	runes := []rune(name)
+	if len(runes) > 31 {
+		runes = runes[:31]
+	}
+	result := string(runes)
+	for i := 2; used[strings.ToLower(result)]; i++ {
+		suffix := " (" + strconv.Itoa(i) + ")"
+		if len(runes)+len(suffix) > 31 {
+			runes = runes[:31-len(suffix)]
+		}
+		result = string(runes) + suffix
+	}
+	used[strings.ToLower(result)] = true
+	return result
+}
@@ -0,0 +1,180 @@
+package tres
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default columns of the stale command if --fields is not given
+const staleFields = "inactivedays,boardname,listname,members,name"
+
+// actions that count as real work on a card for --by-actions
+const workActionFilter = "commentCard,updateCard:idList,createCard,copyCard,convertToCardFromCheckItem"
+
+func (client *TrelloClient) AddComment(cardID, text string) error {
+	q := map[string]string{
+		"text": text,
+	}
+	return client.post("/1/cards/"+strings.TrimSpace(cardID)+"/actions/comments", q, &TrelloAction{})
+}
+
+func (client *TrelloClient) AddLabelToCard(cardID, labelID string) error {
+	q := map[string]string{
+		"value": labelID,
+	}
+	result := []string{}
+	return client.post("/1/cards/"+strings.TrimSpace(cardID)+"/idLabels", q, &result)
+}
+
+func (client *TrelloClient) CreateLabel(boardID, name, color string) (*TrelloLabel, error) {
+	q := map[string]string{
+		"name":  name,
+		"color": color,
+	}
+	result := &TrelloLabel{}
+	err := client.post("/1/boards/"+strings.TrimSpace(boardID)+"/labels", q, result)
+	return result, err
+}
+
+// lastActivity is the time of the last work on a card, from --by-actions if
+// it was looked up, from dateLastActivity otherwise
+func (client *TrelloClient) lastActivity(card *TrelloCardSearchResult) (time.Time, bool) {
+	if t, ok := client.activity[card.ID]; ok {
+		return t, true
+	}
+	return parseTrelloDate(card.DateLastActivity)
+}
+
+func (client *TrelloClient) inactiveDays(card *TrelloCardSearchResult) (int, bool) {
+	t, ok := client.lastActivity(card)
+	if !ok {
+		return 0, false
+	}
+	return int(client.Now().Sub(t) / (24 * time.Hour)), true
+}
+
+// loadWorkActivity sets the last activity of every card to its last comment
+// or list move, label changes or edits of the description do not count
+func (client *TrelloClient) loadWorkActivity(cards []*TrelloCardSearchResult) error {
+	for _, card := range cards {
+		actions, err := client.CardActions(card.ID, workActionFilter)
+		if err != nil {
+			return err
+		}
+		last, _ := cardCreated(card.ID)
+		for _, action := range actions {
+			if t, ok := parseTrelloDate(action.Date); ok && t.After(last) {
+				last = t
+			}
+		}
+		client.activity[card.ID] = last
+	}
+	return nil
+}
+
+// labelForBoard returns the ID of the label with the given name on a board,
+// the label is created without color if it does not exist
+func (client *TrelloClient) labelForBoard(boardID, name string) (string, error) {
+	for _, label := range client.directoryLabels(boardID) {
+		if strings.EqualFold(label.Name, name) {
+			return label.ID, nil
+		}
+	}
+	label, err := client.CreateLabel(boardID, name, "null")
+	if err != nil {
+		return "", err
+	}
+	dir := client.boardDirectory(boardID)
+	dir.labels = append(dir.labels, label)
+	return label.ID, nil
+}
+
+// flagStaleCards adds --comment and --label to every card. Progress goes to
+// stderr so it does not mix with the report.
+func (client *TrelloClient) flagStaleCards(cards []*TrelloCardSearchResult) error {
+	for _, card := range cards {
+		if client.config.Label != "" {
+			labelID, err := client.labelForBoard(card.IDBoard, client.config.Label)
+			if err != nil {
+				return errors.New("Could not find or create label " + client.config.Label + ": " + err.Error())
+			}
+			present := false
+			for _, id := range card.IDLabels {
+				present = present || id == labelID
+			}
+			if !present {
+				if err = client.AddLabelToCard(card.ID, labelID); err != nil {
+					return errors.New("Could not label card " + card.ShortLink + ": " + err.Error())
+				}
+				card.IDLabels = append(card.IDLabels, labelID)
+				fmt.Fprintln(os.Stderr, "labeled", card.ShortLink, card.Name)
+			}
+		}
+		if client.config.Comment != "" {
+			if err := client.AddComment(card.ID, client.config.Comment); err != nil {
+				return errors.New("Could not comment on card " + card.ShortLink + ": " + err.Error())
+			}
+			fmt.Fprintln(os.Stderr, "commented on", card.ShortLink, card.Name)
+		}
+	}
+	return nil
+}
+
+// Stale lists open cards without activity for --days days, grouped by
+// --group-by (default listname) and flags them with --comment or --label.
+func (client *TrelloClient) Stale() error {
+	if client.config.Days <= 0 {
+		return errors.New("--days must be a positive number of days")
+	}
+	query := "is:open" // findCards adds --board and --list
+	if flag.NArg() >= 2 {
+		query = flag.Arg(flag.NArg() - 1)
+	}
+	cards, err := client.findCards(query)
+	if err != nil {
+		return err
+	}
+	if client.config.ByActions {
+		if err = client.loadWorkActivity(cards); err != nil {
+			return errors.New("Could not read card actions: " + err.Error())
+		}
+	}
+
+	stale := []*TrelloCardSearchResult{}
+	for _, card := range cards {
+		if days, ok := client.inactiveDays(card); ok && days >= client.config.Days && !card.Closed {
+			stale = append(stale, card)
+		}
+	}
+	if strings.TrimSpace(client.config.SortFields) == "" {
+		sort.SliceStable(stale, func(i, j int) bool {
+			a, _ := client.inactiveDays(stale[i])
+			b, _ := client.inactiveDays(stale[j])
+			return a > b
+		})
+	}
+	if client.config.SearchResultFields == "name" {
+		client.config.SearchResultFields = staleFields
+	}
+	if strings.TrimSpace(client.config.GroupBy) == "" {
+		client.config.GroupBy = "listname"
+	}
+
+	if err = client.outputCards(stale, client.config.Format); err != nil {
+		fmt.Fprintln(client.out, "Error writing stale cards:", err.Error())
+		return err
+	}
+	if client.config.Comment != "" || client.config.Label != "" {
+		err = client.flagStaleCards(stale)
+		if err == nil {
+			fmt.Fprintln(os.Stderr, strconv.Itoa(len(stale))+" stale cards flagged")
+		}
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// GroupStats holds the aggregated numbers for one group of cards.
+type GroupStats struct {
+	Group             string `json:"group"`
+	Cards             int    `json:"cards"`
+	CheckItems        int    `json:"checkItems"`
+	CheckItemsChecked int    `json:"checkItemsChecked"`
+	Comments          int    `json:"comments"`
+	Overdue           int    `json:"overdue"`
+	OldestActivity    string `json:"oldestActivity"`
+}
+
+var statsHeader = []string{"group", "cards", "checkitems", "checked", "comments", "overdue", "oldestactivity"}
+
+// isOverdue is true for open cards with a due date in the past that are not marked as done
+func isOverdue(card *TrelloCardSearchResult, now time.Time) bool {
+	due, ok := parseTrelloDate(card.Due)
+	return ok && !card.DueComplete && !card.Closed && due.Before(now)
+}
+
+func (stats *GroupStats) add(card *TrelloCardSearchResult, now time.Time) {
+	stats.Cards++
+	if card.Badges != nil {
+		stats.CheckItems += card.Badges.CheckItems
+		stats.CheckItemsChecked += card.Badges.CheckItemsChecked
+		stats.Comments += card.Badges.Comments
+	}
+	if isOverdue(card, now) {
+		stats.Overdue++
+	}
+	// ISO timestamps compare fine as strings
+	if card.DateLastActivity != "" && (stats.OldestActivity == "" || card.DateLastActivity < stats.OldestActivity) {
+		stats.OldestActivity = card.DateLastActivity
+	}
+}
+
+func (stats *GroupStats) columns() []string {
+	return []string{
+		stats.Group,
+		strconv.Itoa(stats.Cards),
+		strconv.Itoa(stats.CheckItems),
+		strconv.Itoa(stats.CheckItemsChecked),
+		strconv.Itoa(stats.Comments),
+		strconv.Itoa(stats.Overdue),
+		stats.OldestActivity,
+	}
+}
+
+// aggregateCards computes the statistics per group and a total over all
+// cards. Cards in several groups are counted once in the total.
+func (client *TrelloClient) aggregateCards(groups []*CardGroup) ([]*GroupStats, *GroupStats) {
+	now := client.Now()
+	result := []*GroupStats{}
+	total := &GroupStats{Group: "Total"}
+	seen := map[*TrelloCardSearchResult]bool{}
+	for _, group := range groups {
+		stats := &GroupStats{Group: group.Name}
+		for _, card := range group.Cards {
+			stats.add(card, now)
+			if !seen[card] {
+				seen[card] = true
+				total.add(card, now)
+			}
+		}
+		result = append(result, stats)
+	}
+	return result, total
+}
+
+func (client *TrelloClient) statsFormatterText(groupBy string, stats []*GroupStats, total *GroupStats) error {
+	header := append([]string{}, statsHeader...)
+	header[0] = groupBy
+	rows := [][]string{header}
+	for _, s := range stats {
+		rows = append(rows, s.columns())
+	}
+	rows = append(rows, total.columns())
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, col := range row {
+			if n := len([]rune(col)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for r, row := range rows {
+		if r == len(rows)-1 {
+			fmt.Fprintln(client.out, strings.Repeat("-", len(strings.Join(formatColumns(rows[0], widths), "  "))))
+		}
+		fmt.Fprintln(client.out, strings.TrimRight(strings.Join(formatColumns(row, widths), "  "), " "))
+		if r == 0 {
+			fmt.Fprintln(client.out, strings.Repeat("-", len(strings.Join(formatColumns(row, widths), "  "))))
+		}
+	}
+	return nil
+}
+
+// formatColumns pads text left-aligned and numbers right-aligned
+func formatColumns(row []string, widths []int) []string {
+	result := make([]string, len(row))
+	for i, col := range row {
+		pad := strings.Repeat(" ", widths[i]-len([]rune(col)))
+		if _, err := strconv.Atoi(col); err == nil {
+			result[i] = pad + col
+		} else {
+			result[i] = col + pad
+		}
+	}
+	return result
+}
+
+func (client *TrelloClient) statsFormatterCsv(groupBy string, stats []*GroupStats, total *GroupStats) error {
+	header := append([]string{}, statsHeader...)
+	header[0] = groupBy
+	fmt.Fprintln(client.out, strings.Join(header, client.config.ColSep))
+	for _, s := range append(stats, total) {
+		cols := s.columns()
+		if client.config.QuoteChar != "" {
+			for i := range cols {
+				cols[i] = client.config.QuoteChar + cols[i] + client.config.QuoteChar
+			}
+		}
+		fmt.Fprint(client.out, strings.Join(cols, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return nil
+}
+
+func (client *TrelloClient) statsFormatterJSON(groupBy string, stats []*GroupStats, total *GroupStats) error {
+	doc, err := json.Marshal(struct {
+		GroupBy string        `json:"groupBy"`
+		Groups  []*GroupStats `json:"groups"`
+		Total   *GroupStats   `json:"total"`
+	}{groupBy, stats, total})
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+func (client *TrelloClient) statsFormatterMarkdown(groupBy string, stats []*GroupStats, total *GroupStats) error {
+	header := append([]string{}, statsHeader...)
+	header[0] = groupBy
+	fmt.Fprintln(client.out, "| "+strings.Join(header, " | ")+" |")
+	fmt.Fprintln(client.out, "|---"+strings.Repeat("|--:", len(header)-2)+"|---|")
+	for _, s := range stats {
+		fmt.Fprintln(client.out, "| "+strings.Join(s.columns(), " | ")+" |")
+	}
+	cols := total.columns()
+	for i := range cols {
+		if cols[i] != "" {
+			cols[i] = "**" + cols[i] + "**"
+		}
+	}
+	fmt.Fprintln(client.out, "| "+strings.Join(cols, " | ")+" |")
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+// statsFormatterExcel writes the summary as a pivot-like first sheet and
+// the cards with their group on a second sheet for further analysis.
+func (client *TrelloClient) statsFormatterExcel(groupBy string, stats []*GroupStats, total *GroupStats, groups []*CardGroup) (err error) {
+	var (
+		file  *xlsx.File
+		sheet *xlsx.Sheet
+		row   *xlsx.Row
+		cell  *xlsx.Cell
+	)
+	client.config.QuoteChar = "" // we do not need quoting in excel
+	file = xlsx.NewFile()
+	if sheet, err = file.AddSheet("Summary"); err != nil {
+		return
+	}
+	row = sheet.AddRow()
+	for _, column := range statsHeader {
+		cell = row.AddCell()
+		cell.Value = strings.Title(column)
+	}
+	row.Cells[0].Value = strings.Title(groupBy)
+	for _, s := range append(stats, total) {
+		row = sheet.AddRow()
+		for _, column := range s.columns() {
+			cell = row.AddCell()
+			cell.Value = column
+		}
+	}
+
+	if sheet, err = file.AddSheet("Cards"); err != nil {
+		return
+	}
+	row = sheet.AddRow()
+	for _, column := range append([]string{groupBy}, strings.Split(client.config.SearchResultFields, ",")...) {
+		cell = row.AddCell()
+		cell.Value = strings.Title(strings.TrimSpace(column))
+	}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			row = sheet.AddRow()
+			for _, column := range append([]string{group.Name}, client.buildOutputLine(card)...) {
+				cell = row.AddCell()
+				cell.Value = column
+			}
+		}
+	}
+
+	return file.Write(client.out)
+}
+
+func (client *TrelloClient) outputStats(cards []*TrelloCardSearchResult, format string) error {
+	if !client.isGrouped() {
+		client.config.GroupBy = "listname"
+	}
+	groups, err := client.groupCards(cards)
+	if err != nil {
+		return err
+	}
+	stats, total := client.aggregateCards(groups)
+	groupBy := strings.ToLower(strings.TrimSpace(client.config.GroupBy))
+	switch strings.ToLower(format) {
+	case "text":
+		err = client.statsFormatterText(groupBy, stats, total)
+	case "csv":
+		err = client.statsFormatterCsv(groupBy, stats, total)
+	case "json":
+		err = client.statsFormatterJSON(groupBy, stats, total)
+	case "excel":
+		err = client.statsFormatterExcel(groupBy, stats, total, groups)
+	case "markdown":
+		err = client.statsFormatterMarkdown(groupBy, stats, total)
+	default:
+		err = errors.New("INVALID_OUTPUT_FORMAT")
+	}
+	return err
+}
+
+// Stats runs a search and prints statistics per --group-by dimension
+// instead of the cards.
+func (client *TrelloClient) Stats() error {
+	client.config.Aggregate = true
+	return client.Search()
+}
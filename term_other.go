@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package tres
+
+import (
+	"errors"
+	"os"
+)
+
+// terminalSize is not supported here, the width comes from $COLUMNS or the
+// default
+func terminalSize(f *os.File) (int, int) {
+	return 0, 0
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("The terminal UI is not supported on this platform")
+}
@@ -6,12 +6,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tealeg/xlsx"
 )
@@ -35,6 +37,32 @@ type Config struct {
 	Format             string
 	BoardName          string
 	ListName           string
+	LocalCards         string
+	SortFields         string
+	GroupBy            string
+	Where              string
+	Aggregate          bool
+	StartList          string
+	EndList            string
+	Since              string
+	From               string
+	To                 string
+	Points             string
+	Overdue            bool
+	Within             string
+	Member             string
+	Days               int
+	ByActions          bool
+	Comment            string
+	Label              string
+	Interval           string
+	Exec               string
+	Listen             string
+	PublicURL          string
+	Queries            string
+	Auth               string
+	CacheTime          string
+	Vars               Vars
 }
 
 type TrelloClient struct {
@@ -44,31 +72,44 @@ type TrelloClient struct {
 	TrelloBoards TrelloNameList
 	TrelloLists  map[string]TrelloNameList
 	config       *Config
+	directories  map[string]*boardDirectory
+	me           *TrelloMember
+	activity     map[string]time.Time // last work on a card by ID, see stale
+	out          io.Writer            // where the formatters write to
+	vars         map[string]string    // ${name} variables in query files
 }
 
 // NewTrelloClient allocates new TrelloClient and reads environment variables.
 func NewTrelloClient(c *Config) *TrelloClient {
+	client := NewOfflineClient(c)
+	if client.TrelloKey == "" {
+		fmt.Fprintln(os.Stderr, "TRELLO_KEY environment variable not set, exiting.")
+		os.Exit(1)
+	}
+	if client.TrelloToken == "" {
+		fmt.Fprintln(os.Stderr, "TRELLO_TOKEN environment variable not set, exiting.")
+		os.Exit(1)
+	}
+	return client
+}
+
+// NewOfflineClient is NewTrelloClient for the commands that work without
+// Trello, like completion, it does not require TRELLO_KEY and TRELLO_TOKEN.
+func NewOfflineClient(c *Config) *TrelloClient {
 	client := &TrelloClient{
 		HTTPClient:  &http.Client{},
 		TrelloLists: make(map[string]TrelloNameList),
 		config:      c,
+		directories: make(map[string]*boardDirectory),
+		activity:    make(map[string]time.Time),
+		out:         os.Stdout,
+		vars:        make(map[string]string),
+		TrelloKey:   os.ExpandEnv("$TRELLO_KEY"),
+		TrelloToken: os.ExpandEnv("$TRELLO_TOKEN"),
 	}
-
-	key := os.ExpandEnv("$TRELLO_KEY")
-	if key == "" {
-		fmt.Println("TRELLO_KEY environment variable not set, exiting.")
-		os.Exit(1)
-	} else {
-		client.TrelloKey = key
+	for name, value := range c.Vars {
+		client.vars[name] = value
 	}
-	tok := os.ExpandEnv("$TRELLO_TOKEN")
-	if key == "" {
-		fmt.Println("TRELLO_TOKEN environment variable not set, exiting.")
-		os.Exit(1)
-	} else {
-		client.TrelloToken = tok
-	}
-
 	return client
 }
 
@@ -105,6 +146,7 @@ type TrelloCardSearchResult struct {
 	Desc                  string         `json:"desc"`
 	DescData              interface{}    `json:"descData"`
 	Due                   string         `json:"due"`
+	DueComplete           bool           `json:"dueComplete"`
 	Email                 string         `json:"email"`
 	IDAttachmentCover     string         `json:"idAttachmentCover"`
 	IDBoard               string         `json:"idBoard"`
@@ -121,7 +163,10 @@ type TrelloCardSearchResult struct {
 	ShortLink             string         `json:"shortLink"`
 	ShortURL              string         `json:"shortUrl"`
 	Subscribed            bool           `json:"subscribed"`
-	URL                   string         `json:"url	"`
+	URL                   string         `json:"url"`
+
+	CustomFieldItems []*TrelloCustomFieldItem `json:"customFieldItems,omitempty"`
+	CustomFields     map[string]interface{}   `json:"customFields,omitempty"` // values by field name, see resolveCustomFields
 }
 
 type TrelloSearchResult struct {
@@ -129,10 +174,26 @@ type TrelloSearchResult struct {
 	Options interface{}
 }
 
-type TrelloCardComment struct {
+type TrelloActionMember struct {
+	AvatarHash string `json:"avatarHash"`
+	FullName   string `json:"fullName"`
+	IDMember   string `json:"id"`
+	Initials   string `json:"initials"`
+	UserName   string `json:"username"`
+}
+
+type TrelloActionList struct {
+	IDList   string `json:"id"`
+	ListName string `json:"name"`
+}
+
+// TrelloAction is an entry of the activity log of a card or board. Which
+// parts of Data are filled depends on the action type, e.g. ListBefore and
+// ListAfter for an "updateCard" that moved the card, Old for other updates.
+type TrelloAction struct {
 	Type            string `json:"type"`
 	Date            string `json:"date"`
-	IDComment       string `json:"id"`
+	IDAction        string `json:"id"`
 	IDMemberCreator string `json:"idMemberCreator"`
 	Data            struct {
 		Board struct {
@@ -140,27 +201,59 @@ type TrelloCardComment struct {
 			BoardName string `json:"name"`
 			ShortLink string `json:"shortLink"`
 		} `json:"board"`
+		BoardSource struct {
+			IDBoard   string `json:"id"`
+			BoardName string `json:"name"`
+		} `json:"boardSource"`
+		BoardTarget struct {
+			IDBoard   string `json:"id"`
+			BoardName string `json:"name"`
+		} `json:"boardTarget"`
 		Card struct {
-			IDCard    string `json:"id"`
-			CardName  string `json:"name"`
-			IDShort   int64  `json:"idShort"`
-			ShortLink string `json:"shortLink"`
+			IDCard      string  `json:"id"`
+			CardName    string  `json:"name"`
+			IDShort     int64   `json:"idShort"`
+			ShortLink   string  `json:"shortLink"`
+			IDList      string  `json:"idList"`
+			Closed      bool    `json:"closed"`
+			Due         string  `json:"due"`
+			DueComplete bool    `json:"dueComplete"`
+			Desc        string  `json:"desc"`
+			Pos         float64 `json:"pos"`
 		} `json:"card"`
-		List struct {
-			IDList   string `json:"id"`
-			ListName string `json:"name"`
-		} `json:"list"`
-		Text string `json:"text"`
+		List       TrelloActionList       `json:"list"`
+		ListBefore TrelloActionList       `json:"listBefore"`
+		ListAfter  TrelloActionList       `json:"listAfter"`
+		Old        map[string]interface{} `json:"old"`
+		Text       string                 `json:"text"`
+		IDMember   string                 `json:"idMember"`
+		Label      struct {
+			IDLabel string `json:"id"`
+			Name    string `json:"name"`
+			Color   string `json:"color"`
+		} `json:"label"`
+		Checklist struct {
+			IDChecklist string `json:"id"`
+			Name        string `json:"name"`
+		} `json:"checklist"`
+		CheckItem struct {
+			IDCheckItem string `json:"id"`
+			Name        string `json:"name"`
+			State       string `json:"state"`
+		} `json:"checkItem"`
+		Attachment struct {
+			IDAttachment string `json:"id"`
+			Name         string `json:"name"`
+			URL          string `json:"url"`
+		} `json:"attachment"`
 	} `json:"data"`
-	MemberCreator struct {
-		AvatarHash string `json:"avatarHash"`
-		FullName   string `json:"fullName"`
-		IDMember   string `json:"id"`
-		Initials   string `json:"initials"`
-		UserName   string `json:"username"`
-	} `json:"memberCreator"`
+	MemberCreator TrelloActionMember  `json:"memberCreator"`
+	Member        *TrelloActionMember `json:"member"`
 }
 
+// TrelloCardComment is a "commentCard" action.
+type TrelloCardComment = TrelloAction
+
 type TrelloChecklist struct {
 	IDChecklist string  `json:"id"`
 	IDBoard     string  `json:"idBoard"`
@@ -235,13 +328,23 @@ func (client *TrelloClient) prepareQuery(path string, query map[string]string) *
 	return theURL
 }
 
+// statusError is the error of a request Trello answered with an HTTP error
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return "HTTP Status " + e.Status
+}
+
 func processResponse(resp *http.Response, err error, result interface{}) error {
 
 	if err != nil {
 		return err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New("HTTP Status " + resp.Status)
+		return &statusError{resp.StatusCode, resp.Status}
 	}
 	data, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
@@ -253,6 +356,40 @@ func processResponse(resp *http.Response, err error, result interface{}) error {
 	return err
 }
 
+// post sends a POST request with the parameters in the query string
+func (client *TrelloClient) post(path string, query map[string]string, result interface{}) error {
+	theURL := client.prepareQuery(path, query)
+	req, err := http.NewRequest("POST", theURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.HTTPClient.Do(req)
+	return processResponse(resp, err, result)
+}
+
+// put sends a PUT request with the parameters in the query string, like post
+func (client *TrelloClient) put(path string, query map[string]string, result interface{}) error {
+	theURL := client.prepareQuery(path, query)
+	req, err := http.NewRequest("PUT", theURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.HTTPClient.Do(req)
+	return processResponse(resp, err, result)
+}
+
+// delete sends a DELETE request for the object at path
+func (client *TrelloClient) delete(path string) error {
+	theURL := client.prepareQuery(path, map[string]string{})
+	req, err := http.NewRequest("DELETE", theURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.HTTPClient.Do(req)
+	var result interface{}
+	return processResponse(resp, err, &result)
+}
+
 func (client *TrelloClient) TrelloNamesFromURL(theURL string) (TrelloNameList, error) {
 	result := TrelloNameList{}
 	resp, err := client.HTTPClient.Get(theURL)
@@ -306,6 +443,17 @@ func (client *TrelloClient) FetchBoardMembers(boardID string) ([]*TrelloMember,
 	return result, err
 }
 
+func (client *TrelloClient) FetchMember(memberID string) (*TrelloMember, error) {
+	q := map[string]string{
+		"fields": "all",
+	}
+	theURL := client.prepareQuery("/1/members/"+strings.TrimSpace(memberID), q)
+	result := &TrelloMember{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
 func (client *TrelloClient) CardComments(cardID string) ([]*TrelloCardComment, error) {
 	q := map[string]string{
 		"filter": "commentCard",
@@ -368,93 +516,158 @@ func (client *TrelloClient) FetchBoardInfo() error {
 	return nil
 }
 
-func (client *TrelloClient) buildOutputLine(card *TrelloCardSearchResult) []string {
-	list := strings.Split(client.config.SearchResultFields, ",")
-	result := []string{}
-	for _, v := range list {
-		var item string
-		v = strings.TrimSpace(strings.ToLower(v))
-		switch v {
-		case "id":
-			item = card.ID
-		case "attachmentcount":
-			item = strconv.Itoa(card.Badges.Attachments)
-		case "checked":
-			item = strconv.Itoa(card.Badges.CheckItemsChecked) + "/" + strconv.Itoa(card.Badges.CheckItems)
-		case "commentcount":
-			item = strconv.Itoa(card.Badges.Comments)
-		case "hasdesc":
-			item = strconv.FormatBool(card.Badges.Description)
-		case "closed":
-			item = strconv.FormatBool(card.Closed)
-		case "datelastactivity":
-			item = card.DateLastActivity
-		case "desc":
-			item = card.Desc
-		case "due":
-			item = card.Due
-		case "email":
-			item = card.Email
-		case "idattachmentcover":
-			item = card.IDAttachmentCover
-		case "idboard":
-			item = card.IDBoard
-		case "labels":
-			labels := []string{}
-			for _, v := range card.Labels {
-				s := v.Name
-				if s == "" {
-					s = strings.ToUpper(v.Color)
-				}
-				labels = append(labels, "["+s+"]")
-			}
-			item = strings.Join(labels, " ")
-		case "labelcolors":
-			labels := []string{}
-			for _, v := range card.Labels {
-				s := v.Color
+func (client *TrelloClient) fieldValue(card *TrelloCardSearchResult, field string) string {
+	var item string
+	field = strings.TrimSpace(strings.ToLower(field))
+	switch field {
+	case "id":
+		item = card.ID
+	case "attachmentcount":
+		item = strconv.Itoa(card.Badges.Attachments)
+	case "checked":
+		item = strconv.Itoa(card.Badges.CheckItemsChecked) + "/" + strconv.Itoa(card.Badges.CheckItems)
+	case "checkitems":
+		item = strconv.Itoa(card.Badges.CheckItems)
+	case "checkitemschecked":
+		item = strconv.Itoa(card.Badges.CheckItemsChecked)
+	case "checkedratio":
+		if card.Badges.CheckItems > 0 {
+			item = strconv.Itoa(100*card.Badges.CheckItemsChecked/card.Badges.CheckItems) + "%"
+		}
+	case "votes":
+		item = strconv.Itoa(card.Badges.Votes)
+	case "commentcount":
+		item = strconv.Itoa(card.Badges.Comments)
+	case "hasdesc":
+		item = strconv.FormatBool(card.Badges.Description)
+	case "closed":
+		item = strconv.FormatBool(card.Closed)
+	case "datelastactivity":
+		item = card.DateLastActivity
+	case "desc":
+		item = card.Desc
+	case "due":
+		item = card.Due
+	case "duecomplete":
+		item = strconv.FormatBool(card.DueComplete)
+	case "duedate":
+		if due, ok := parseTrelloDate(card.Due); ok {
+			item = due.Local().Format("2006-01-02 15:04")
+		}
+	case "duerelative":
+		if due, ok := parseTrelloDate(card.Due); ok {
+			item = relativeTime(due, client.Now())
+		}
+	case "overdue":
+		item = strconv.FormatBool(isOverdue(card, client.Now()))
+	case "inactivedays":
+		if days, ok := client.inactiveDays(card); ok {
+			item = strconv.Itoa(days)
+		}
+	case "created":
+		if t, ok := cardCreated(card.ID); ok {
+			item = t.Format(time.RFC3339)
+		}
+	case "email":
+		item = card.Email
+	case "idattachmentcover":
+		item = card.IDAttachmentCover
+	case "idboard":
+		item = card.IDBoard
+	case "labels":
+		labels := []string{}
+		for _, v := range client.cardLabels(card) {
+			s := v.Name
+			if s == "" {
 				s = strings.ToUpper(v.Color)
-				labels = append(labels, "["+s+"]")
-			}
-			item = strings.Join(labels, " ")
-		case "idlist":
-			item = card.IDList
-		case "listname":
-			s := NameFromID(card.IDBoard, client.TrelloBoards)
-			if s != "" {
-				s = strings.ToLower(s)
-				s = NameFromID(card.IDList, client.TrelloLists[s])
 			}
-			item = s
-		case "boardname":
-			item = NameFromID(card.IDBoard, client.TrelloBoards)
-		case "idshort":
-			item = strconv.Itoa(card.IDShort)
-		case "name":
-			item = card.Name
-		case "pos":
-			item = strconv.FormatFloat(card.Pos, 'g', 2, 64)
-		case "shortlink":
-			item = card.ShortLink
-		case "shorturl":
-			item = card.ShortURL
-		case "subscribed":
-			item = strconv.FormatBool(card.Subscribed)
-		case "comments":
-			item = ""
-			if card.Badges.Comments > 0 {
-				comments, err := client.CardComments(card.ID)
-				if err == nil {
-					for _, comment := range comments {
-						item += "@" + comment.MemberCreator.UserName + " on " + comment.Date + ": " + strings.Replace(comment.Data.Text, "\n", "\\n", -1) + "\n"
-					}
-				} else {
-					item = "[Could not read comments for card] " + err.Error()
+			labels = append(labels, "["+s+"]")
+		}
+		item = strings.Join(labels, " ")
+	case "labelcolors":
+		labels := []string{}
+		for _, v := range client.cardLabels(card) {
+			s := v.Color
+			s = strings.ToUpper(v.Color)
+			labels = append(labels, "["+s+"]")
+		}
+		item = strings.Join(labels, " ")
+	case "idlist":
+		item = card.IDList
+	case "idlabels":
+		item = strings.Join(card.IDLabels, " ")
+	case "idmembers":
+		item = strings.Join(card.IDMembers, " ")
+	case "idmembersvoted":
+		item = strings.Join(card.IDMembersVoted, " ")
+	case "idchecklists":
+		item = strings.Join(card.IDChecklists, " ")
+	case "members":
+		item = strings.Join(client.memberUserNames(card.IDBoard, card.IDMembers), " ")
+	case "memberinitials":
+		item = strings.Join(client.memberInitials(card.IDBoard, card.IDMembers), " ")
+	case "voters":
+		item = strings.Join(client.memberUserNames(card.IDBoard, card.IDMembersVoted), " ")
+	case "checklistnames":
+		names := []string{}
+		for _, name := range client.checklistNames(card) {
+			names = append(names, "["+name+"]")
+		}
+		item = strings.Join(names, " ")
+	case "attachmentnames":
+		var err error
+		item, err = client.attachmentNames(card)
+		if err != nil {
+			item = "[Could not read attachments for card] " + err.Error()
+		}
+	case "listname":
+		s := NameFromID(card.IDBoard, client.TrelloBoards)
+		if s != "" {
+			s = strings.ToLower(s)
+			s = NameFromID(card.IDList, client.TrelloLists[s])
+		}
+		item = s
+	case "boardname":
+		item = NameFromID(card.IDBoard, client.TrelloBoards)
+	case "idshort":
+		item = strconv.Itoa(card.IDShort)
+	case "name":
+		item = card.Name
+	case "pos":
+		item = strconv.FormatFloat(card.Pos, 'g', 2, 64)
+	case "shortlink":
+		item = card.ShortLink
+	case "shorturl":
+		item = card.ShortURL
+	case "subscribed":
+		item = strconv.FormatBool(card.Subscribed)
+	case "comments":
+		item = ""
+		if card.Badges.Comments > 0 {
+			comments, err := client.CardComments(card.ID)
+			if err == nil {
+				for _, comment := range comments {
+					item += "@" + comment.MemberCreator.UserName + " on " + comment.Date + ": " + strings.Replace(comment.Data.Text, "\n", "\\n", -1) + "\n"
 				}
+			} else {
+				item = "[Could not read comments for card] " + err.Error()
 			}
-		case "url":
-			item = card.URL
 		}
+	case "url":
+		item = card.URL
+	default:
+		if isCustomField(field) {
+			item, _ = client.customFieldValue(card, customFieldName(field))
+		}
+	}
+	return item
+}
+
+func (client *TrelloClient) buildOutputLine(card *TrelloCardSearchResult) []string {
+	list := strings.Split(client.config.SearchResultFields, ",")
+	result := []string{}
+	for _, v := range list {
+		item := client.fieldValue(card, v)
 		if client.config.QuoteChar != "" {
 			item = client.config.QuoteChar + item + client.config.QuoteChar
 		}
@@ -463,150 +676,194 @@ func (client *TrelloClient) buildOutputLine(card *TrelloCardSearchResult) []stri
 	return result
 }
 
-func (client *TrelloClient) formatterText(cards []*TrelloCardSearchResult) error {
+func (client *TrelloClient) formatterText(groups []*CardGroup) error {
 	var err error
-	fmt.Printf("Found %d cards\n", len(cards))
-	fmt.Println()
+	count := 0
+	for _, group := range groups {
+		count += len(group.Cards)
+	}
+	fmt.Fprintf(client.out, "Found %d cards\n", count)
+	fmt.Fprintln(client.out)
 	header := strings.Split(client.config.SearchResultFields, ",")
-	for i, card := range cards {
-		if client.config.NumberOutput {
-			fmt.Printf("%4d ", i)
-		}
-		cols := client.buildOutputLine(card)
-		for i := range header {
-			fmt.Printf("%-25s: ", strings.Title(header[i]))
-			if strings.ToLower(header[i]) == "comments" { // do a break before comments
-				fmt.Println()
-			}
-			fmt.Println(cols[i])
+	i := 0
+	for _, group := range groups {
+		if client.isGrouped() {
+			fmt.Fprintf(client.out, "=== %s (%d cards) ===\n", group.Name, len(group.Cards))
+			fmt.Fprintln(client.out)
 		}
+		for _, card := range group.Cards {
+			if client.config.NumberOutput {
+				fmt.Fprintf(client.out, "%4d ", i)
+			}
+			i++
+			cols := client.buildOutputLine(card)
+			for i := range header {
+				fmt.Fprintf(client.out, "%-25s: ", strings.Title(header[i]))
+				if strings.ToLower(header[i]) == "comments" { // do a break before comments
+					fmt.Fprintln(client.out)
+				}
+				fmt.Fprintln(client.out, cols[i])
+			}
 
-		if card.Badges.CheckItems > 0 {
-			chklists, err := client.CardChecklists(card.ID)
-			if err != nil {
-				fmt.Println("[Could not read checklist items for card] ", err.Error())
-			} else {
-				fmt.Println("Checklists")
-
-				for _, chklist := range chklists {
-					fmt.Println(chklist.Name)
-					for i, v := range chklist.CheckItems {
-						s := fmt.Sprintf("%2d: %s ", i+1, v.Name)
-						if v.State == "complete" {
-							s += " ✅ (done)"
+			if card.Badges.CheckItems > 0 {
+				chklists, err := client.CardChecklists(card.ID)
+				if err != nil {
+					fmt.Fprintln(client.out, "[Could not read checklist items for card] ", err.Error())
+				} else {
+					fmt.Fprintln(client.out, "Checklists")
+
+					for _, chklist := range chklists {
+						fmt.Fprintln(client.out, chklist.Name)
+						for i, v := range chklist.CheckItems {
+							s := fmt.Sprintf("%2d: %s ", i+1, v.Name)
+							if v.State == "complete" {
+								s += " ✅ (done)"
+							}
+							fmt.Fprintln(client.out, s)
 						}
-						fmt.Println(s)
 					}
+					fmt.Fprintln(client.out)
 				}
-				fmt.Println()
 			}
-		}
 
-		fmt.Println("--------")
+			fmt.Fprintln(client.out, "--------")
+		}
 	}
 	return err
 }
 
-func (client *TrelloClient) formatterCsv(cards []*TrelloCardSearchResult) error {
+func (client *TrelloClient) formatterCsv(groups []*CardGroup) error {
 	var err error
-	header := strings.Join(strings.Split(client.config.SearchResultFields, ","), client.config.ColSep)
-	fmt.Println(header)
-	for _, card := range cards {
-		card.Desc = strings.Replace(card.Desc, "\n", "\\n", -1)
-		colbuf := client.buildOutputLine(card)
-		fmt.Print(strings.Join(colbuf, client.config.ColSep))
-		fmt.Print(client.config.RowSep)
+	header := strings.Split(client.config.SearchResultFields, ",")
+	if client.isGrouped() {
+		header = append([]string{"group"}, header...)
+	}
+	fmt.Fprintln(client.out, strings.Join(header, client.config.ColSep))
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			card.Desc = strings.Replace(card.Desc, "\n", "\\n", -1)
+			colbuf := client.buildOutputLine(card)
+			if client.isGrouped() {
+				colbuf = append([]string{client.config.QuoteChar + group.Name + client.config.QuoteChar}, colbuf...)
+			}
+			fmt.Fprint(client.out, strings.Join(colbuf, client.config.ColSep))
+			fmt.Fprint(client.out, client.config.RowSep)
+		}
 	}
 	return err
 }
 
-func (client *TrelloClient) formatterJSON(cards []*TrelloCardSearchResult) error {
-	var err error
-	doc := []byte{}
-	doc, err = json.Marshal(cards)
+type groupedCard struct {
+	Group string `json:"group"`
+	*TrelloCardSearchResult
+}
+
+// jsonCards is the value the json format writes, the cards or with
+// grouping the cards with their group
+func (client *TrelloClient) jsonCards(groups []*CardGroup) interface{} {
+	if !client.isGrouped() {
+		return groups[0].Cards
+	}
+	cards := []*groupedCard{}
+	for _, group := range groups {
+		for _, card := range group.Cards {
+			cards = append(cards, &groupedCard{group.Name, card})
+		}
+	}
+	return cards
+}
+
+func (client *TrelloClient) formatterJSON(groups []*CardGroup) error {
+	doc, err := json.Marshal(client.jsonCards(groups))
 	if err == nil {
-		fmt.Print(string(doc))
-		fmt.Print(client.config.RowSep)
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
 	}
 	return err
 }
 
-func (client *TrelloClient) formatterExcel(cards []*TrelloCardSearchResult) (err error) {
-	var (
-		file  *xlsx.File
-		sheet *xlsx.Sheet
-		row   *xlsx.Row
-		cell  *xlsx.Cell
-	)
-
-	client.config.QuoteChar = "" // we do not need quoting in excel
-	file = xlsx.NewFile()
-	if sheet, err = file.AddSheet("Sheet1"); err != nil {
-		return
+func (client *TrelloClient) addCardSheet(file *xlsx.File, name string, cards []*TrelloCardSearchResult, used map[string]bool) error {
+	sheet, err := file.AddSheet(sheetName(name, used))
+	if err != nil {
+		return err
 	}
 	for _, card := range cards {
-		row = sheet.AddRow()
+		row := sheet.AddRow()
 		for _, column := range client.buildOutputLine(card) {
-			cell = row.AddCell()
+			cell := row.AddCell()
 			cell.Value = column
 		}
 	}
-	if err != nil {
-		fmt.Printf(err.Error())
-	}
+	return nil
+}
 
-	err = file.Write(os.Stdout)
-	if err != nil {
-		fmt.Printf(err.Error())
+func (client *TrelloClient) formatterExcel(groups []*CardGroup) (err error) {
+	var file *xlsx.File
+
+	client.config.QuoteChar = "" // we do not need quoting in excel
+	file = xlsx.NewFile()
+	used := map[string]bool{}
+	for _, group := range groups {
+		if err = client.addCardSheet(file, group.Name, group.Cards, used); err != nil {
+			return
+		}
 	}
 
-	return err
+	return file.Write(client.out)
 }
 
-func (client *TrelloClient) formatterMarkdown(cards []*TrelloCardSearchResult) error {
+// formatterMarkdown writes the cards as headings of the given level, grouped
+// cards one level below the headings of their groups
+func (client *TrelloClient) formatterMarkdown(groups []*CardGroup, level int) error {
 	var err error
-	for _, card := range cards {
-		linebuf := []string{}
-		linebuf = append(linebuf, "# "+strings.TrimSpace(card.Name))
-		s := ""
-		for _, label := range card.Labels {
-			if label.Name == "" {
-				label.Name = "[" + strings.ToUpper(label.Color) + "]"
-			}
-			s += "<span style=\"background-color: " + label.Color + ";\">" + label.Name + "</span> "
+	g := strings.Repeat("#", level) // heading prefix for a group
+	h := g                          // heading prefix for a card, one level deeper when grouped
+	if client.isGrouped() {
+		h += "#"
+	}
+	for _, group := range groups {
+		if client.isGrouped() {
+			fmt.Fprint(client.out, g+" "+group.Name+"\n\n")
 		}
-		linebuf = append(linebuf, s)
-		linebuf = append(linebuf, "")
-		linebuf = append(linebuf, "## Description")
-		linebuf = append(linebuf, card.Desc)
-
-		if card.Badges.Comments > 0 {
-			comments, err := client.CardComments(card.ID)
-			if err == nil {
+		for _, card := range group.Cards {
+			d := client.cardDetails(card)
+			linebuf := []string{}
+			linebuf = append(linebuf, h+" "+strings.TrimSpace(card.Name))
+			s := ""
+			for _, label := range d.Labels {
+				name := label.Name
+				if name == "" {
+					name = "[" + strings.ToUpper(label.Color) + "]"
+				}
+				s += "<span style=\"background-color: " + label.Color + ";\">" + name + "</span> "
+			}
+			linebuf = append(linebuf, s)
+			linebuf = append(linebuf, "")
+			linebuf = append(linebuf, h+"# Description")
+			linebuf = append(linebuf, card.Desc)
+
+			if d.CommentsErr != nil {
+				linebuf = append(linebuf, "[Could not read comments for card] ", d.CommentsErr.Error())
+			} else if len(d.Comments) > 0 {
 				linebuf = append(linebuf, "")
-				linebuf = append(linebuf, "## Card Comments")
-				for _, comment := range comments {
+				linebuf = append(linebuf, h+"# Card Comments")
+				for _, comment := range d.Comments {
 					linebuf = append(linebuf, "")
-					linebuf = append(linebuf, "### "+comment.Date+" from @"+comment.MemberCreator.UserName)
+					linebuf = append(linebuf, h+"## "+comment.Date+" from @"+comment.MemberCreator.UserName)
 					linebuf = append(linebuf, "")
 					linebuf = append(linebuf, comment.Data.Text)
 					linebuf = append(linebuf, "")
 				}
-			} else {
-				linebuf = append(linebuf, "[Could not read comments for card] ", err.Error())
 			}
-		}
 
-		if card.Badges.CheckItems > 0 {
-			chklists, err := client.CardChecklists(card.ID)
-			if err != nil {
-				linebuf = append(linebuf, "[Could not read checklist items for card] ", err.Error())
-			} else {
+			if d.ChecklistsErr != nil {
+				linebuf = append(linebuf, "[Could not read checklist items for card] ", d.ChecklistsErr.Error())
+			} else if len(d.Checklists) > 0 {
 				linebuf = append(linebuf, "")
-				linebuf = append(linebuf, "## Checklists")
+				linebuf = append(linebuf, h+"# Checklists")
 
-				for _, chklist := range chklists {
-					linebuf = append(linebuf, "### "+chklist.Name)
+				for _, chklist := range d.Checklists {
+					linebuf = append(linebuf, h+"## "+chklist.Name)
 					for _, v := range chklist.CheckItems {
 						s := " 1. " + v.Name
 						if v.State == "complete" {
@@ -617,21 +874,23 @@ func (client *TrelloClient) formatterMarkdown(cards []*TrelloCardSearchResult) e
 				}
 				linebuf = append(linebuf, "")
 			}
-		}
 
-		linebuf = append(linebuf, "## Card Info")
-		linebuf = append(linebuf, " * last activity on "+card.DateLastActivity)
-		if card.Due != "" {
-			linebuf = append(linebuf, " * due on "+card.Due)
+			linebuf = append(linebuf, h+"# Card Info")
+			linebuf = append(linebuf, " * last activity on "+card.DateLastActivity)
+			if card.Due != "" {
+				linebuf = append(linebuf, " * due on "+card.Due)
+			}
+			linebuf = append(linebuf, " * card shortUrl ["+card.ShortURL+"]("+card.ShortURL+")")
+			linebuf = append(linebuf, " * board "+d.BoardName)
+			linebuf = append(linebuf, " * list "+d.ListName)
+			for _, field := range d.CustomFields {
+				linebuf = append(linebuf, " * "+field[0]+": "+field[1])
+			}
+			linebuf = append(linebuf, "")
+			linebuf = append(linebuf, "")
+			fmt.Fprint(client.out, strings.Join(linebuf, "\n"))
+			fmt.Fprint(client.out, client.config.RowSep)
 		}
-		linebuf = append(linebuf, " * card shortUrl ["+card.ShortURL+"]("+card.ShortURL+")")
-		boardName := NameFromID(card.IDBoard, client.TrelloBoards)
-		linebuf = append(linebuf, " * board "+boardName)
-		linebuf = append(linebuf, " * list "+NameFromID(card.IDList, client.TrelloLists[strings.ToLower(boardName)]))
-		linebuf = append(linebuf, "")
-		linebuf = append(linebuf, "")
-		fmt.Print(strings.Join(linebuf, "\n"))
-		fmt.Print(client.config.RowSep)
 	}
 	return err
 }
@@ -679,8 +938,8 @@ func (client *TrelloClient) memberFormatterJSON(members []*TrelloMember) error {
 	doc := []byte{}
 	doc, err = json.Marshal(members)
 	if err == nil {
-		fmt.Print(string(doc))
-		fmt.Print(client.config.RowSep)
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
 	}
 	return err
 }
@@ -688,14 +947,14 @@ func (client *TrelloClient) memberFormatterJSON(members []*TrelloMember) error {
 func (client *TrelloClient) memberFormatterText(members []*TrelloMember) error {
 	var err error
 	header := strings.Split(client.config.SearchResultFields, ",")
-	fmt.Println(header)
+	fmt.Fprintln(client.out, header)
 	for _, member := range members {
 		colbuf := client.buildMemberSlice(member)
 		for i := 0; i < len(header); i++ {
-			fmt.Printf("%-20s : %s\n", header[i], colbuf[i])
+			fmt.Fprintf(client.out, "%-20s : %s\n", header[i], colbuf[i])
 		}
-		fmt.Print("--------")
-		fmt.Print(client.config.RowSep)
+		fmt.Fprint(client.out, "--------")
+		fmt.Fprint(client.out, client.config.RowSep)
 	}
 	return err
 }
@@ -703,12 +962,12 @@ func (client *TrelloClient) memberFormatterText(members []*TrelloMember) error {
 func (client *TrelloClient) memberFormatterCsv(members []*TrelloMember) error {
 	var err error
 	header := strings.Join(strings.Split(client.config.SearchResultFields, ","), client.config.ColSep)
-	fmt.Println(header)
+	fmt.Fprintln(client.out, header)
 	for _, member := range members {
 		member.Bio = strings.Replace(member.Bio, "\n", "\\n", -1)
 		colbuf := client.buildMemberSlice(member)
-		fmt.Print(strings.Join(colbuf, client.config.ColSep))
-		fmt.Print(client.config.RowSep)
+		fmt.Fprint(client.out, strings.Join(colbuf, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
 	}
 	return err
 }
@@ -739,16 +998,7 @@ func (client *TrelloClient) memberFormatterExcel(members []*TrelloMember) (err e
 			cell.Value = column
 		}
 	}
-	if err != nil {
-		fmt.Printf(err.Error())
-	}
-
-	err = file.Write(os.Stdout)
-	if err != nil {
-		fmt.Printf(err.Error())
-	}
-
-	return err
+	return file.Write(client.out)
 }
 
 func (client *TrelloClient) memberFormatterMarkdown(members []*TrelloMember) error {
@@ -756,29 +1006,54 @@ func (client *TrelloClient) memberFormatterMarkdown(members []*TrelloMember) err
 	header := strings.Split(client.config.SearchResultFields, ",")
 	for _, member := range members {
 		colbuf := client.buildMemberSlice(member)
-		fmt.Println("## " + member.FullName)
-		fmt.Println()
+		fmt.Fprintln(client.out, "## "+member.FullName)
+		fmt.Fprintln(client.out)
 		for i, headercol := range header {
-			fmt.Printf(" * " + strings.Title(headercol) + ": " + colbuf[i] + "\n")
+			fmt.Fprint(client.out, " * "+strings.Title(headercol)+": "+colbuf[i]+"\n")
 		}
-		fmt.Print(client.config.RowSep)
+		fmt.Fprint(client.out, client.config.RowSep)
 	}
 	return err
 }
 
+// prepareCards sorts and groups the cards for the formatters
+func (client *TrelloClient) prepareCards(cards []*TrelloCardSearchResult) ([]*CardGroup, error) {
+	err := client.sortCards(cards)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := client.groupCards(cards)
+	if err != nil {
+		return nil, err
+	}
+	if client.usesCustomFields() {
+		client.resolveCustomFields(cards)
+	}
+	return groups, nil
+}
+
 func (client *TrelloClient) outputCards(cards []*TrelloCardSearchResult, format string) error {
-	var err error
+	groups, err := client.prepareCards(cards)
+	if err != nil {
+		return err
+	}
 	switch strings.ToLower(format) {
 	case "text":
-		err = client.formatterText(cards)
+		err = client.formatterText(groups)
 	case "csv":
-		err = client.formatterCsv(cards)
+		err = client.formatterCsv(groups)
 	case "json":
-		err = client.formatterJSON(cards)
+		err = client.formatterJSON(groups)
 	case "excel":
-		err = client.formatterExcel(cards)
+		err = client.formatterExcel(groups)
 	case "markdown":
-		err = client.formatterMarkdown(cards)
+		err = client.formatterMarkdown(groups, 1)
+	case "ical":
+		err = client.formatterICal(groups)
+	case "html":
+		err = client.formatterHTML(groups)
+	case "kanban":
+		err = client.formatterKanban(groups)
 	default:
 		err = errors.New("INVALID_OUTPUT_FORMAT")
 	}
@@ -806,9 +1081,9 @@ func (client *TrelloClient) outputMembers(cards []*TrelloMember, format string)
 
 func (client *TrelloClient) handleAtCommand(command string) error {
 	var err error
-	command = strings.ToLower(command)
 	cmd := strings.Split(command, " ")[0]
-	parms := strings.TrimSpace(strings.Replace(command, cmd, "", -1))
+	parms := strings.TrimSpace(strings.TrimPrefix(command, cmd))
+	cmd = strings.ToLower(cmd)
 
 	switch cmd {
 	case "@fields":
@@ -821,31 +1096,68 @@ func (client *TrelloClient) handleAtCommand(command string) error {
 		client.config.RowSep = parms
 	case "@limit":
 		client.config.CardLimit, err = strconv.Atoi(parms)
+	case "@sort":
+		client.config.SortFields = parms
+	case "@groupby":
+		client.config.GroupBy = parms
+	case "@where":
+		client.config.Where = parms
+	case "@param":
+		err = client.declareParam(parms)
 	}
 	return err
 }
 
-func (client *TrelloClient) parseQuery(queryString string) (string, error) {
-	var err error
-	lines := strings.Split(queryString, "\n")
-	query := ""
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		line = strings.Split(line, "//")[0] // dirty trick to get rid of the comments, if any
-		if line != "" {
-			if line[0] == '@' {
-				err = client.handleAtCommand(line)
-				if err != nil {
-					return "", err
-				}
-			} else {
-				query += line + " "
+// parseQuery reads the lines of a query file, runs the at-commands and
+// returns the search query, or the sections if the file has @section.
+// Filename is the file the lines are from, @include paths are relative to
+// its directory.
+func (client *TrelloClient) parseQuery(queryString, filename string) (string, []*querySection, error) {
+	// the defaults of @param only apply to this query
+	vars := client.vars
+	client.vars = make(map[string]string, len(vars))
+	for name, value := range vars {
+		client.vars[name] = value
+	}
+	defer func() { client.vars = vars }()
+
+	p := &queryFile{client: client, fragments: map[string][]string{}, using: map[string]bool{}}
+	err := p.parseFile(queryString, filename)
+	if err != nil || p.sections == nil {
+		return p.query, nil, err
+	}
+	// the settings before the first section are those of the report
+	p.endSection()
+	*client.config = *p.base
+	for _, section := range p.sections {
+		if strings.TrimSpace(section.Query) == "" {
+			return "", nil, errors.New("Section " + section.Title + " has no query")
+		}
+	}
+	return "", p.sections, nil
+}
+
+// stripComment removes a // line comment that is not inside double or single
+// quotes, a single quote within a word like "don't" is not a quote
+func stripComment(line string) string {
+	var quote byte // the quote character of the open quoted text
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == quote {
+				quote = 0
 			}
+		case line[i] == '"' || line[i] == '\'' && (i == 0 || !isWordChar(line[i-1])):
+			quote = line[i]
+		case strings.HasPrefix(line[i:], "//"):
+			return strings.TrimSpace(line[:i])
 		}
 	}
+	return line
+}
 
-	return query, err
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
 }
 
 func isFile(filename string) bool {
@@ -858,47 +1170,108 @@ func isFile(filename string) bool {
 	return true
 }
 
-func (client *TrelloClient) loadQuery(filename string) (string, error) {
+func (client *TrelloClient) loadQuery(filename string) (string, []*querySection, error) {
 	var err error
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return client.parseQuery(string(data))
+	return client.parseQuery(string(data), filename)
 }
 
-func (client *TrelloClient) Search() error {
-	var err error
-	query := flag.Arg(flag.NArg() - 1)
-	limit := client.config.CardLimit
+// searchTerm quotes a name for a search operator like board: or list:,
+// search has no way to escape a double quote so they are dropped
+func searchTerm(operator, name string) string {
+	return operator + ":" + quoteName(strings.Replace(name, "\"", "", -1))
+}
+
+// scopeQuery restricts a search query to --board and --list
+func (client *TrelloClient) scopeQuery(query string) string {
+	if client.config.BoardName != "" {
+		query += " " + searchTerm("board", client.config.BoardName)
+	}
+	if client.config.ListName != "" {
+		query += " " + searchTerm("list", client.config.ListName)
+	}
+	return strings.TrimSpace(query)
+}
+
+// findCards runs a literal search query or the query in a file, scoped to
+// --board and --list, and applies the --where filter to the result
+func (client *TrelloClient) findCards(query string) ([]*TrelloCardSearchResult, error) {
+	cards, sections, err := client.findReport(query)
+	if err == nil && sections != nil {
+		err = errors.New("Could not load query: @section is only supported by search, shell and serve")
+	}
+	return cards, err
+}
 
+// findReport is findCards for the commands that also run query files with
+// sections, for those it returns the sections instead of cards
+func (client *TrelloClient) findReport(query string) ([]*TrelloCardSearchResult, []*querySection, error) {
 	if isFile(query) {
-		query, err = client.loadQuery(query)
+		var sections []*querySection
+		var err error
+		query, sections, err = client.loadQuery(query)
 		if err != nil {
-			fmt.Println("Could not load query:", err.Error())
-			return err
+			return nil, nil, errors.New("Could not load query: " + err.Error())
+		}
+		if sections != nil {
+			return nil, sections, nil
 		}
 	}
+	cards, err := client.searchQuery(query)
+	return cards, nil, err
+}
 
-	cards, err := client.SearchCards(query, limit)
+// searchQuery searches the cards of a literal query
+func (client *TrelloClient) searchQuery(query string) ([]*TrelloCardSearchResult, error) {
+	var err error
+	query = client.scopeQuery(query)
+
+	limit := client.config.CardLimit
+	var cards []*TrelloCardSearchResult
+	if client.config.LocalCards != "" {
+		cards, err = client.SearchLocalCards(query, limit)
+	} else {
+		cards, err = client.SearchCards(query, limit)
+	}
+	if err == nil {
+		cards, err = client.applyWhere(cards)
+	}
 	if err != nil {
-		fmt.Println("Error searching for cards:", err.Error())
+		return nil, fmt.Errorf("Error searching for cards: %w", err)
+	}
+	return cards, nil
+}
+
+func (client *TrelloClient) Search() error {
+	query := flag.Arg(flag.NArg() - 1)
+	cards, sections, err := client.findReport(query)
+	if err != nil {
+		fmt.Fprintln(client.out, err.Error())
 	} else {
-		err = client.outputCards(cards, client.config.Format)
+		if sections != nil {
+			err = client.outputReport(reportTitle(query), sections, client.config.Format)
+		} else if client.config.Aggregate {
+			err = client.outputStats(cards, client.config.Format)
+		} else {
+			err = client.outputCards(cards, client.config.Format)
+		}
 		if err != nil {
-			fmt.Println("Error writing search result:", err.Error())
+			fmt.Fprintln(client.out, "Error writing search result:", err.Error())
 		}
 	}
 	return err
 }
 
 func (client *TrelloClient) FetchAllMembers() error {
-	var err error
-	board := flag.Arg(flag.NArg() - 1)
-	boardID := IDFromName(board, client.TrelloBoards)
-	members, err := client.FetchBoardMembers(boardID)
+	boardID, _, err := client.boardFromArg()
 	if err != nil {
-	} else {
+		return err
+	}
+	members, err := client.FetchBoardMembers(boardID)
+	if err == nil {
 		err = client.outputMembers(members, client.config.Format)
 	}
 	return err
@@ -911,11 +1284,11 @@ func (client *TrelloClient) FetchAllBoards() error {
 		err = errors.New("Format not supported for this operation.")
 	}
 	for _, board := range client.TrelloBoards {
-		fmt.Println("Board" + client.config.ColSep + board.Name + client.config.ColSep + board.ID)
+		fmt.Fprintln(client.out, "Board"+client.config.ColSep+board.Name+client.config.ColSep+board.ID)
 		for _, list := range client.TrelloLists[strings.ToLower(board.Name)] {
-			fmt.Println("List" + client.config.ColSep + list.Name + client.config.ColSep + list.ID)
+			fmt.Fprintln(client.out, "List"+client.config.ColSep+list.Name+client.config.ColSep+list.ID)
 		}
-		fmt.Println()
+		fmt.Fprintln(client.out)
 	}
 	return err
 }
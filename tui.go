@@ -0,0 +1,541 @@
+package tres
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (client *TrelloClient) MoveCard(cardID, listID string) error {
+	q := map[string]string{
+		"idList": listID,
+	}
+	return client.put("/1/cards/"+strings.TrimSpace(cardID), q, &TrelloCardSearchResult{})
+}
+
+func (client *TrelloClient) ArchiveCard(cardID string) error {
+	q := map[string]string{
+		"closed": "true",
+	}
+	return client.put("/1/cards/"+strings.TrimSpace(cardID), q, &TrelloCardSearchResult{})
+}
+
+const browserHelp = "enter open  / search  m move  l label  c comment  a archive  r reload  q quit"
+
+// CardBrowser is the full screen terminal UI of the ui command. It reads
+// keys from in and draws on out, so it runs without a terminal as well,
+// e.g. with the keys from a string and a client whose HTTPClient fakes
+// the Trello API.
+type CardBrowser struct {
+	client    *TrelloClient
+	in        *bufio.Reader
+	out       io.Writer
+	size      func() (int, int)
+	query     string
+	cards     []*TrelloCardSearchResult
+	selected  int
+	top       int
+	details   *cardDetails // the open card, nil in the list
+	detailTop int
+	prompt    string // label of the input line, "" without input
+	input     string
+	onInput   func(string)
+	onConfirm func() // run if the next key is y
+	message   string
+}
+
+// NewCardBrowser creates a browser for a screen of width x height characters
+func NewCardBrowser(client *TrelloClient, in io.Reader, out io.Writer, width, height int) *CardBrowser {
+	return &CardBrowser{
+		client: client,
+		in:     bufio.NewReader(in),
+		out:    out,
+		size: func() (int, int) {
+			return width, height
+		},
+	}
+}
+
+// readKey returns the next key from a terminal in raw mode, a character or
+// the name of a special key like "enter", "up" or "ctrl-d"
+func readKey(in *bufio.Reader) (string, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case '\r', '\n':
+		return "enter", nil
+	case '\t':
+		return "tab", nil
+	case 127, 8:
+		return "backspace", nil
+	case 1:
+		return "home", nil
+	case 3:
+		return "ctrl-c", nil
+	case 4:
+		return "ctrl-d", nil
+	case 5:
+		return "end", nil
+	case 21:
+		return "ctrl-u", nil
+	case 27:
+		// an escape sequence arrives in one piece, a lone ESC is the key
+		if in.Buffered() == 0 {
+			return "esc", nil
+		}
+		if next, _, _ := in.ReadRune(); next != '[' && next != 'O' {
+			return "esc", nil
+		}
+		seq := ""
+		for {
+			c, _, err := in.ReadRune()
+			if err != nil {
+				return "esc", nil
+			}
+			seq += string(c)
+			if c >= 0x40 && c <= 0x7e {
+				break
+			}
+		}
+		return escapeKeys[seq], nil
+	}
+	return string(r), nil
+}
+
+var escapeKeys = map[string]string{
+	"A":  "up",
+	"B":  "down",
+	"C":  "right",
+	"D":  "left",
+	"5~": "pgup",
+	"6~": "pgdown",
+	"H":  "home",
+	"1~": "home",
+	"F":  "end",
+	"4~": "end",
+	"3~": "delete",
+}
+
+// Run shows the cards of the query, or asks for one if it is empty, until
+// q is pressed or the input ends
+func (b *CardBrowser) Run(query string) error {
+	fmt.Fprint(b.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(b.out, "\x1b[2J\x1b[?25h\x1b[?1049l")
+	if query != "" {
+		b.search(query)
+	} else {
+		b.ask("Search: ", "", b.search)
+	}
+	for {
+		b.draw()
+		key, err := readKey(b.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.handleKey(key) {
+			return nil
+		}
+	}
+}
+
+func (b *CardBrowser) ask(prompt, input string, done func(string)) {
+	b.prompt, b.input, b.onInput = prompt, input, done
+}
+
+func (b *CardBrowser) search(query string) {
+	if strings.TrimSpace(query) == "" {
+		return
+	}
+	b.query = query
+	cards, err := b.client.findCards(query)
+	if err != nil {
+		b.message = err.Error()
+		return
+	}
+	b.cards, b.selected, b.top, b.details = cards, 0, 0, nil
+	b.message = plural(len(cards), "card")
+	if err = b.client.sortCards(cards); err != nil {
+		b.message = err.Error() // the cards are shown unsorted
+	}
+}
+
+// current is the open card or the selected card of the list
+func (b *CardBrowser) current() *TrelloCardSearchResult {
+	if b.details != nil {
+		return b.details.Card
+	}
+	if b.selected < len(b.cards) {
+		return b.cards[b.selected]
+	}
+	return nil
+}
+
+// handleKey reacts to a key and returns true to quit
+func (b *CardBrowser) handleKey(key string) bool {
+	if b.onConfirm != nil {
+		if key == "y" || key == "Y" {
+			b.onConfirm()
+		} else {
+			b.message = "cancelled"
+		}
+		b.onConfirm = nil
+		return false
+	}
+	if b.prompt != "" {
+		switch key {
+		case "enter":
+			input, done := b.input, b.onInput
+			b.prompt, b.input, b.onInput = "", "", nil
+			done(input)
+		case "esc", "ctrl-c":
+			b.prompt, b.input, b.onInput = "", "", nil
+		case "backspace":
+			if r := []rune(b.input); len(r) > 0 {
+				b.input = string(r[:len(r)-1])
+			}
+		default:
+			if len([]rune(key)) == 1 {
+				b.input += key
+			}
+		}
+		return false
+	}
+
+	_, height := b.size()
+	page := height - 3
+	if page < 1 {
+		page = 1
+	}
+	b.message = ""
+	card := b.current()
+	switch key {
+	case "q":
+		if b.details == nil {
+			return true
+		}
+		b.details = nil
+	case "ctrl-c":
+		return true
+	case "esc", "backspace", "h":
+		b.details = nil
+	case "j", "down":
+		b.scroll(1)
+	case "k", "up":
+		b.scroll(-1)
+	case "pgdown", " ":
+		b.scroll(page)
+	case "pgup":
+		b.scroll(-page)
+	case "g", "home":
+		b.scroll(-1 << 20)
+	case "G", "end":
+		b.scroll(1 << 20)
+	case "enter":
+		if b.details == nil && card != nil {
+			b.details, b.detailTop = b.client.cardDetails(card), 0
+		}
+	case "/":
+		b.ask("Search: ", b.query, b.search)
+	case "r":
+		b.search(b.query)
+	case "m", "l", "c", "a":
+		if card == nil {
+			b.message = "No card selected"
+			break
+		}
+		switch key {
+		case "m":
+			b.ask("Move to list: ", "", func(name string) { b.moveCard(card, name) })
+		case "l":
+			b.ask("Add label: ", "", func(name string) { b.labelCard(card, name) })
+		case "c":
+			b.ask("Comment: ", "", func(text string) { b.commentCard(card, text) })
+		case "a":
+			b.message = "Archive " + strconv.Quote(card.Name) + "? (y/n)"
+			b.onConfirm = func() { b.archiveCard(card) }
+		}
+	}
+	return false
+}
+
+func (b *CardBrowser) scroll(delta int) {
+	if b.details != nil {
+		b.detailTop += delta
+		if b.detailTop < 0 {
+			b.detailTop = 0
+		}
+		return
+	}
+	b.selected += delta
+	if b.selected >= len(b.cards) {
+		b.selected = len(b.cards) - 1
+	}
+	if b.selected < 0 {
+		b.selected = 0
+	}
+}
+
+func (b *CardBrowser) moveCard(card *TrelloCardSearchResult, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	listID := IDFromName(name, b.client.TrelloLists[strings.ToLower(b.client.BoardName(card))])
+	if listID == "" {
+		b.message = "Unknown list " + name
+		return
+	}
+	if err := b.client.MoveCard(card.ID, listID); err != nil {
+		b.message = "Could not move card: " + err.Error()
+		return
+	}
+	card.IDList = listID
+	if b.details != nil {
+		b.details.ListName = b.client.ListName(card)
+	}
+	b.message = "Moved to " + b.client.ListName(card)
+}
+
+func (b *CardBrowser) labelCard(card *TrelloCardSearchResult, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	labelID, err := b.client.labelForBoard(card.IDBoard, name)
+	if err == nil {
+		for _, id := range card.IDLabels {
+			if id == labelID {
+				b.message = "The card already has label " + name
+				return
+			}
+		}
+		err = b.client.AddLabelToCard(card.ID, labelID)
+	}
+	if err != nil {
+		b.message = "Could not label card: " + err.Error()
+		return
+	}
+	card.IDLabels = append(card.IDLabels, labelID)
+	for _, label := range b.client.directoryLabels(card.IDBoard) {
+		if label.ID == labelID && len(card.Labels) > 0 {
+			card.Labels = append(card.Labels, label)
+		}
+	}
+	if b.details != nil {
+		b.details.Labels = b.client.cardLabels(card)
+	}
+	b.message = "Added label " + name
+}
+
+func (b *CardBrowser) commentCard(card *TrelloCardSearchResult, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if err := b.client.AddComment(card.ID, text); err != nil {
+		b.message = "Could not comment on card: " + err.Error()
+		return
+	}
+	if card.Badges != nil {
+		card.Badges.Comments++
+	}
+	if b.details != nil {
+		b.details = b.client.cardDetails(card)
+	}
+	b.message = "Comment added"
+}
+
+func (b *CardBrowser) archiveCard(card *TrelloCardSearchResult) {
+	if err := b.client.ArchiveCard(card.ID); err != nil {
+		b.message = "Could not archive card: " + err.Error()
+		return
+	}
+	card.Closed = true
+	for i, c := range b.cards {
+		if c == card {
+			b.cards = append(b.cards[:i], b.cards[i+1:]...)
+			break
+		}
+	}
+	b.details = nil
+	b.scroll(0)
+	b.message = "Archived " + strconv.Quote(card.Name)
+}
+
+func (b *CardBrowser) listLines(width, height int) []string {
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if b.selected >= b.top+height {
+		b.top = b.selected - height + 1
+	}
+	listWidth := width / 4
+	lines := []string{}
+	for i := b.top; i < len(b.cards) && i < b.top+height; i++ {
+		card := b.cards[i]
+		line := fmt.Sprintf("%-*s  %s", listWidth, truncate(b.client.ListName(card), listWidth), strings.TrimSpace(card.Name))
+		if due, ok := parseTrelloDate(card.Due); ok && !card.DueComplete {
+			line += "  (due " + relativeTime(due, b.client.Now()) + ")"
+		}
+		line = truncate(line, width)
+		if i == b.selected {
+			line = "\x1b[7m" + line + strings.Repeat(" ", width-len([]rune(line))) + ansiReset
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (b *CardBrowser) detailLines(width, height int) []string {
+	d := b.details
+	card := d.Card
+	lines := []string{ansiBold + strings.TrimSpace(card.Name) + ansiReset, d.BoardName + " › " + d.ListName}
+	if len(d.Labels) > 0 {
+		names := []string{}
+		for _, label := range d.Labels {
+			names = append(names, labelText(label))
+		}
+		lines = append(lines, "Labels:  "+strings.Join(names, ", "))
+	}
+	if len(d.Members) > 0 {
+		lines = append(lines, "Members: @"+strings.Join(d.Members, ", @"))
+	}
+	if due, ok := parseTrelloDate(card.Due); ok {
+		s := "Due:     " + localDate(card.Due) + " (" + relativeTime(due, b.client.Now()) + ")"
+		if card.DueComplete {
+			s += " done"
+		}
+		lines = append(lines, s)
+	}
+	for _, field := range d.CustomFields {
+		lines = append(lines, field[0]+": "+field[1])
+	}
+	if card.ShortURL != "" {
+		lines = append(lines, card.ShortURL)
+	}
+	if strings.TrimSpace(card.Desc) != "" {
+		lines = append(lines, "")
+		for _, para := range strings.Split(card.Desc, "\n") {
+			lines = append(lines, wrapText(para, width)...)
+		}
+	}
+	if d.ChecklistsErr != nil {
+		lines = append(lines, "", "Could not read checklists: "+d.ChecklistsErr.Error())
+	}
+	for _, chklist := range d.Checklists {
+		lines = append(lines, "", ansiBold+chklist.Name+ansiReset+" ("+strconv.Itoa(checklistProgress(chklist))+"%)")
+		for _, item := range chklist.CheckItems {
+			box := "[ ] "
+			if item.State == "complete" {
+				box = "[x] "
+			}
+			for i, line := range wrapText(item.Name, width-6) {
+				if i > 0 {
+					box = "    "
+				}
+				lines = append(lines, "  "+box+line)
+			}
+		}
+	}
+	if d.CommentsErr != nil {
+		lines = append(lines, "", "Could not read comments: "+d.CommentsErr.Error())
+	}
+	for _, comment := range d.Comments {
+		lines = append(lines, "", ansiBold+"@"+comment.MemberCreator.UserName+ansiReset+"  "+localDate(comment.Date))
+		for _, para := range strings.Split(comment.Data.Text, "\n") {
+			for _, line := range wrapText(para, width-2) {
+				lines = append(lines, "  "+line)
+			}
+		}
+	}
+	if b.detailTop > len(lines)-height {
+		b.detailTop = len(lines) - height
+	}
+	if b.detailTop < 0 {
+		b.detailTop = 0
+	}
+	lines = lines[b.detailTop:]
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, "\x1b") {
+			lines[i] = truncate(line, width)
+		}
+	}
+	return lines
+}
+
+// draw writes the whole screen: a title line, the cards or the open card
+// and a status line with the input, a message or the keys
+func (b *CardBrowser) draw() {
+	width, height := b.size()
+	if width < 20 {
+		width = 20
+	}
+	if height < 5 {
+		height = 5
+	}
+	title := "tres  " + b.query
+	if b.details == nil && len(b.cards) > 0 {
+		title += "  [" + strconv.Itoa(b.selected+1) + "/" + strconv.Itoa(len(b.cards)) + "]"
+	}
+	var body []string
+	if b.details != nil {
+		body = b.detailLines(width, height-2)
+	} else {
+		body = b.listLines(width, height-2)
+	}
+	status := b.message
+	switch {
+	case b.prompt != "":
+		status = b.prompt + b.input + "_"
+	case status == "":
+		status = browserHelp
+	}
+
+	screen := "\x1b[H\x1b[2J" + ansiBold + truncate(title, width) + ansiReset + "\r\n"
+	for i := 0; i < height-2; i++ {
+		if i < len(body) {
+			screen += body[i]
+		}
+		screen += "\r\n"
+	}
+	screen += truncate(status, width)
+	fmt.Fprint(b.out, screen)
+}
+
+// UI browses the cards of the query given on the command line in a full
+// screen terminal interface
+func (client *TrelloClient) UI() error {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return errors.New("The ui command needs a terminal")
+	}
+	query := ""
+	if flag.NArg() >= 2 {
+		query = flag.Arg(flag.NArg() - 1)
+	}
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	browser := NewCardBrowser(client, os.Stdin, os.Stdout, 80, 24)
+	browser.size = func() (int, int) {
+		width, height := terminalSize(os.Stdout)
+		if width == 0 || height == 0 {
+			return 80, 24
+		}
+		return width, height
+	}
+	return browser.Run(query)
+}
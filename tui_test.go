@@ -0,0 +1,160 @@
+package tres
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeTrello answers the requests of a client with canned JSON by method
+// and path and records every request
+type fakeTrello struct {
+	responses map[string]string // "GET /1/search" -> body
+	status    int               // the status of the other requests, 404 if 0
+	requests  []*http.Request
+}
+
+func (f *fakeTrello) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, req)
+	body, ok := f.responses[req.Method+" "+req.URL.Path]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+		if f.status != 0 {
+			status = f.status
+		}
+		body = http.StatusText(status)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// sent returns the requests other than GET as "METHOD path key=value..."
+func (f *fakeTrello) sent() []string {
+	result := []string{}
+	for _, req := range f.requests {
+		if req.Method == "GET" {
+			continue
+		}
+		q := req.URL.Query()
+		q.Del("key")
+		q.Del("token")
+		result = append(result, req.Method+" "+req.URL.Path+" "+q.Encode())
+	}
+	return result
+}
+
+func newFakeClient(f *fakeTrello) *TrelloClient {
+	client := NewOfflineClient(&Config{CardLimit: 100, SearchResultFields: "name"})
+	client.HTTPClient = &http.Client{Transport: f}
+	client.TrelloBoards = TrelloNameList{{ID: "b1", Name: "Team Board"}}
+	client.TrelloLists["team board"] = TrelloNameList{{ID: "l1", Name: "Doing"}, {ID: "l2", Name: "Done"}}
+	return client
+}
+
+func TestCardBrowser(t *testing.T) {
+	f := &fakeTrello{responses: map[string]string{
+		"GET /1/search": `{"cards": [
+			{"id": "c1", "name": "Fix login bug", "idBoard": "b1", "idList": "l1", "badges": {}},
+			{"id": "c2", "name": "Write docs", "idBoard": "b1", "idList": "l1", "desc": "All of them", "badges": {}}]}`,
+		"GET /1/boards/b1/labels":           `[{"id": "lb1", "name": "bug", "color": "red", "idBoard": "b1"}]`,
+		"PUT /1/cards/c2":                   `{"id": "c2"}`,
+		"POST /1/cards/c2/idLabels":         `["lb1"]`,
+		"POST /1/cards/c2/actions/comments": `{"id": "a1"}`,
+	}}
+	client := newFakeClient(f)
+
+	steps := []struct {
+		keys   string
+		screen []string
+	}{
+		{"", []string{"Search: _"}},
+		{"is:open\r", []string{"[1/2]", "Fix login bug", "Write docs"}},
+		{"j", []string{"[2/2]"}},
+		{"\r", []string{"Team Board › Doing", "All of them"}},
+		{"m", []string{"Move to list: _"}},
+		{"Done\r", []string{"Moved to Done", "Team Board › Done"}},
+		{"l", []string{"Add label: _"}},
+		{"bug\r", []string{"Added label bug", "Labels:  bug"}},
+		{"c", []string{"Comment: _"}},
+		{"looks good\r", []string{"Comment added"}},
+		{"a", []string{`Archive "Write docs"? (y/n)`}},
+		{"y", []string{`Archived "Write docs"`, "[1/1]", "Fix login bug"}},
+		{"q", nil},
+	}
+	keys := ""
+	for _, step := range steps {
+		keys += step.keys
+	}
+	out := &bytes.Buffer{}
+	if err := NewCardBrowser(client, strings.NewReader(keys), out, 80, 24).Run(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a screen before the first key and after every key but the final q
+	screens := strings.Split(out.String(), "\x1b[H\x1b[2J")[1:]
+	if len(screens) != len(keys) {
+		t.Fatalf("got %d screens for %d keys", len(screens), len(keys))
+	}
+	n := 0
+	for _, step := range steps {
+		n += len(step.keys)
+		for _, s := range step.screen {
+			if !strings.Contains(screens[n], s) {
+				t.Errorf("after %q the screen does not contain %q:\n%s", step.keys, s, screens[n])
+			}
+		}
+	}
+	lines := strings.Split(screens[len(screens)-1], "\r\n")
+	if list := strings.Join(lines[1:len(lines)-1], "\n"); strings.Contains(list, "Write docs") {
+		t.Errorf("the archived card is still listed:\n%s", list)
+	}
+
+	want := []string{
+		"PUT /1/cards/c2 idList=l2",
+		"POST /1/cards/c2/idLabels value=lb1",
+		"POST /1/cards/c2/actions/comments text=looks+good",
+		"PUT /1/cards/c2 closed=true",
+	}
+	if got := f.sent(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sent\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCardBrowserCancelArchive(t *testing.T) {
+	f := &fakeTrello{responses: map[string]string{
+		"GET /1/search": `{"cards": [{"id": "c1", "name": "Fix login bug", "idBoard": "b1", "idList": "l1", "badges": {}}]}`,
+	}}
+	out := &bytes.Buffer{}
+	if err := NewCardBrowser(newFakeClient(f), strings.NewReader("anq"), out, 80, 24).Run("is:open"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := f.sent(); len(sent) > 0 {
+		t.Errorf("sent %v after n", sent)
+	}
+	if !strings.Contains(out.String(), "cancelled") {
+		t.Errorf("no cancelled message:\n%s", out.String())
+	}
+}
+
+func TestCardBrowserSortError(t *testing.T) {
+	f := &fakeTrello{responses: map[string]string{
+		"GET /1/search": `{"cards": [{"id": "c1", "name": "Fix login bug", "idBoard": "b1", "idList": "l1", "badges": {}}]}`,
+	}}
+	client := newFakeClient(f)
+	client.config.SortFields = "name:sideways"
+	out := &bytes.Buffer{}
+	if err := NewCardBrowser(client, strings.NewReader("q"), out, 80, 24).Run("is:open"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Invalid sort direction") || !strings.Contains(out.String(), "Fix login bug") {
+		t.Errorf("the sort error or the cards are missing:\n%s", out.String())
+	}
+}
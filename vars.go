@@ -0,0 +1,124 @@
+package tres
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Vars are the variables given with --set name=value, the option can be
+// repeated
+type Vars map[string]string
+
+func (v *Vars) String() string {
+	if v == nil {
+		return ""
+	}
+	names := []string{}
+	for name := range *v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + (*v)[name]
+	}
+	return strings.Join(names, ",")
+}
+
+func (v *Vars) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 || !varName.MatchString(s[:i]) {
+		return errors.New("use name=value, a name consists of letters, digits and _")
+	}
+	if *v == nil {
+		*v = Vars{}
+	}
+	(*v)[s[:i]] = s[i+1:]
+	return nil
+}
+
+func day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// today is midnight in the local time zone
+func (client *TrelloClient) today() time.Time {
+	return startOfDay(client.Now().Local())
+}
+
+// weekStart is the Monday of the week of t
+func weekStart(t time.Time) time.Time {
+	return t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+}
+
+// builtinVars are the variables that are always defined, unless set with
+// --set or a URL parameter. They are only computed when used, ${me} needs
+// a request if $TRELLO_USER is not set.
+var builtinVars = map[string]func(client *TrelloClient) string{
+	"today":     func(client *TrelloClient) string { return day(client.today()) },
+	"yesterday": func(client *TrelloClient) string { return day(client.today().AddDate(0, 0, -1)) },
+	"tomorrow":  func(client *TrelloClient) string { return day(client.today().AddDate(0, 0, 1)) },
+	"now":       func(client *TrelloClient) string { return client.Now().Local().Format("2006-01-02T15:04") },
+	"week_start": func(client *TrelloClient) string {
+		return day(weekStart(client.today()))
+	},
+	"week_end": func(client *TrelloClient) string {
+		return day(weekStart(client.today()).AddDate(0, 0, 6))
+	},
+	"month_start": func(client *TrelloClient) string {
+		t := client.today()
+		return day(t.AddDate(0, 0, 1-t.Day()))
+	},
+	"month_end": func(client *TrelloClient) string {
+		t := client.today()
+		return day(t.AddDate(0, 1, -t.Day()))
+	},
+	"year": func(client *TrelloClient) string { return strconv.Itoa(client.Now().Local().Year()) },
+	"me":   func(client *TrelloClient) string { return client.Me() },
+}
+
+// expandVars replaces every ${name} in a line of a query file with the value
+// of the variable, an undefined variable is an error
+func (client *TrelloClient) expandVars(line string) (string, error) {
+	var err error
+	result := varPattern.ReplaceAllStringFunc(line, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := client.vars[name]
+		if !ok {
+			if builtin, found := builtinVars[name]; found {
+				value, ok = builtin(client), true
+			}
+		}
+		if !ok && err == nil {
+			err = errors.New("Undefined variable ${" + name + "}")
+		}
+		return value
+	})
+	return result, err
+}
+
+// declareParam handles "@param name default", the variable gets the default
+// unless it is already set. Without a default it has to be set with --set.
+func (client *TrelloClient) declareParam(parms string) error {
+	fields := strings.Fields(parms)
+	if len(fields) == 0 || !varName.MatchString(fields[0]) {
+		return errors.New("Invalid @param, use: @param <name> [<default>]")
+	}
+	name := fields[0]
+	if _, ok := client.vars[name]; ok {
+		return nil
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(parms, name))
+	if value == "" {
+		return errors.New("Missing parameter " + name + ", use --set " + name + "=<value>")
+	}
+	client.vars[name] = value
+	return nil
+}
@@ -0,0 +1,242 @@
+package tres
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the board actions watch reports
+const watchActionFilter = diffActionFilter + ",commentCard"
+
+// WatchEvent is a change reported by watch, one JSON object per line with
+// --format json and the input of the --exec hook.
+type WatchEvent struct {
+	Time  string `json:"time"`
+	Board string `json:"board,omitempty"`
+	*CardChange
+	Member string `json:"member,omitempty"` // who made the change, board actions only
+}
+
+// boardActionsSince returns the newest actions after since, an action ID or
+// a date, oldest first. The ETag of the last response is sent along, if
+// nothing happened Trello answers 304 and we get no actions.
+func (client *TrelloClient) boardActionsSince(boardID, filter, since string, limit int, etag *string) ([]*TrelloAction, error) {
+	q := map[string]string{
+		"filter": filter,
+		"limit":  strconv.Itoa(limit),
+	}
+	if since != "" {
+		q["since"] = since
+	}
+	theURL := client.prepareQuery("/1/boards/"+strings.TrimSpace(boardID)+"/actions", q)
+	req, err := http.NewRequest("GET", theURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if *etag != "" {
+		req.Header.Set("If-None-Match", *etag)
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err == nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, nil
+	}
+	result := []*TrelloAction{}
+	if err = processResponse(resp, err, &result); err != nil {
+		return nil, err
+	}
+	*etag = resp.Header.Get("ETag")
+	sortActions(result)
+	return result, nil
+}
+
+// actionEvents turns a card action into events, other actions have none
+func actionEvents(action *TrelloAction, boardName string) []*WatchEvent {
+	at, _ := parseTrelloDate(action.Date)
+	events := []*WatchEvent{}
+	for _, change := range actionChanges(action) {
+		events = append(events, &WatchEvent{
+			Time:       at.Format(time.RFC3339),
+			Board:      boardName,
+			CardChange: change,
+			Member:     action.MemberCreator.UserName,
+		})
+	}
+	return events
+}
+
+// boardWatcher polls the actions of a board. The first poll only remembers
+// the newest action, then the actions after it are reported.
+func (client *TrelloClient) boardWatcher(boardID, boardName string) func() ([]*WatchEvent, error) {
+	lastID, etag := "", ""
+	startTime := client.Now().UTC().Format(time.RFC3339)
+	started := false
+	return func() ([]*WatchEvent, error) {
+		var actions []*TrelloAction
+		var err error
+		switch {
+		case !started:
+			actions, err = client.boardActionsSince(boardID, watchActionFilter, "", 1, &etag)
+		case lastID == "":
+			// a board without actions so far
+			actions, err = client.boardActionsSince(boardID, watchActionFilter, startTime, 1000, &etag)
+		default:
+			actions, err = client.boardActionsSince(boardID, watchActionFilter, lastID, 1000, &etag)
+		}
+		if err != nil {
+			return nil, err
+		}
+		events := []*WatchEvent{}
+		for _, action := range actions {
+			lastID = action.IDAction
+			if started {
+				events = append(events, actionEvents(action, boardName)...)
+			}
+		}
+		if !started {
+			started = true
+			etag = "" // the first request had a different limit
+		}
+		return events, nil
+	}
+}
+
+// queryWatcher runs the search again and again and compares the results,
+// cards that show up are "added", cards that no longer match "removed".
+// The first poll only remembers the cards.
+func (client *TrelloClient) queryWatcher(query string) func() ([]*WatchEvent, error) {
+	var before map[string]*cardSnapshot
+	comments := map[string]int{}
+	// names are kept between polls for the cards that are gone
+	boards, lists, labels, members := map[string]string{}, map[string]string{}, map[string]string{}, map[string]string{}
+	return func() ([]*WatchEvent, error) {
+		cards, err := client.findCards(query)
+		if err != nil {
+			return nil, err
+		}
+		d := &BoardDiff{
+			Before:  before,
+			After:   map[string]*cardSnapshot{},
+			lists:   lists,
+			labels:  labels,
+			members: members,
+		}
+		commented := []*CardChange{}
+		for _, card := range cards {
+			d.After[card.ID] = newCardSnapshot(card)
+			d.lists[card.IDList] = client.ListName(card)
+			boards[card.ID] = client.BoardName(card)
+			for _, label := range client.cardLabels(card) {
+				d.labels[label.ID] = labelDisplayName(label.Name, label.Color)
+			}
+			for i, name := range client.MemberNames(card) {
+				d.members[card.IDMembers[i]] = name
+			}
+			if card.Badges != nil {
+				if n, ok := comments[card.ID]; ok && card.Badges.Comments > n {
+					commented = append(commented, &CardChange{"commented", card.ID, card.Name, card.ShortLink, "", ""})
+				}
+				comments[card.ID] = card.Badges.Comments
+			}
+		}
+		if before == nil {
+			before = d.After
+			return nil, nil
+		}
+		d.compare()
+		before = d.After
+
+		now := client.Now().Format(time.RFC3339)
+		events := []*WatchEvent{}
+		for _, change := range append(d.Changes, commented...) {
+			events = append(events, &WatchEvent{Time: now, Board: boards[change.IDCard], CardChange: change})
+		}
+		return events, nil
+	}
+}
+
+// runHook runs the --exec command with the event JSON on stdin, its output
+// goes to stderr to keep the event stream on stdout clean
+func runHook(command string, input []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (client *TrelloClient) emitEvent(event *WatchEvent, format string) error {
+	doc, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		fmt.Fprintln(client.out, string(doc))
+	} else {
+		t, _ := time.Parse(time.RFC3339, event.Time)
+		line := t.Local().Format("2006-01-02 15:04:05") + "  " + event.Change + "  " + describeChange(event.CardChange, event.Card)
+		if event.Member != "" {
+			line += "  (@" + event.Member + ")"
+		}
+		fmt.Fprintln(client.out, line)
+	}
+	if client.config.Exec != "" {
+		if err = runHook(client.config.Exec, doc); err != nil {
+			fmt.Fprintln(os.Stderr, "Error running "+client.config.Exec+": "+err.Error())
+		}
+	}
+	return nil
+}
+
+// Watch polls a board or a search query every --interval and prints a line
+// per change until it is interrupted.
+func (client *TrelloClient) Watch() error {
+	format := strings.ToLower(client.config.Format)
+	if format != "text" && format != "json" {
+		return errors.New("Format not supported for this operation.")
+	}
+	interval, err := time.ParseDuration(client.config.Interval)
+	if err != nil || interval < time.Second {
+		return errors.New("Invalid --interval '" + client.config.Interval + "', use e.g. 30s or 5m")
+	}
+	if flag.NArg() < 2 && client.config.BoardName == "" {
+		return errors.New("Missing board name or search query")
+	}
+
+	var poll func() ([]*WatchEvent, error)
+	if boardID, boardName, err := client.boardFromArg(); err == nil {
+		poll = client.boardWatcher(boardID, boardName)
+	} else if flag.NArg() >= 2 {
+		poll = client.queryWatcher(flag.Arg(flag.NArg() - 1))
+	} else {
+		return err
+	}
+	for {
+		events, err := poll()
+		if err != nil {
+			// keep watching, Trello or the network may be back soon
+			fmt.Fprintln(os.Stderr, "Error polling Trello: "+err.Error())
+		}
+		for _, event := range events {
+			if err = client.emitEvent(event, format); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing event: "+err.Error())
+			}
+		}
+		time.Sleep(interval)
+	}
+}
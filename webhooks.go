@@ -0,0 +1,254 @@
+package tres
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+)
+
+type TrelloWebhook struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	IDModel     string `json:"idModel"`
+	CallbackURL string `json:"callbackURL"`
+	Active      bool   `json:"active"`
+}
+
+// WebhookPayload is the body Trello posts to the callback URL of a webhook.
+// Model is the board the webhook was registered for.
+type WebhookPayload struct {
+	Action *TrelloAction `json:"action"`
+	Model  struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"model"`
+}
+
+// WebhookListener gets every webhook payload with a valid signature. Calls
+// are serialized, an error is logged and answered with HTTP 500.
+type WebhookListener interface {
+	HandleAction(payload *WebhookPayload) error
+}
+
+// WebhookHandler is the http.Handler for the webhook callback. Secret is
+// the Trello application secret, CallbackURL the URL exactly as registered
+// because it is part of the signature.
+type WebhookHandler struct {
+	Secret      string
+	CallbackURL string
+	Listener    WebhookListener
+	mutex       sync.Mutex
+}
+
+// SignWebhookPayload computes the X-Trello-Webhook header, the base64
+// encoded HMAC-SHA1 of body and callback URL with the application secret.
+func SignWebhookPayload(secret string, body []byte, callbackURL string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	mac.Write([]byte(callbackURL))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "HEAD":
+		// Trello checks the callback URL before it creates the webhook
+		w.WriteHeader(http.StatusOK)
+		return
+	case "POST":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	signature := SignWebhookPayload(h.Secret, body, h.CallbackURL)
+	if !hmac.Equal([]byte(signature), []byte(r.Header.Get("X-Trello-Webhook"))) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	payload := &WebhookPayload{}
+	if err = json.Unmarshal(body, payload); err != nil || payload.Action == nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	h.mutex.Lock()
+	err = h.Listener.HandleAction(payload)
+	h.mutex.Unlock()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error handling webhook: "+err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (client *TrelloClient) Webhooks() ([]*TrelloWebhook, error) {
+	q := map[string]string{}
+	theURL := client.prepareQuery("/1/tokens/"+client.TrelloToken+"/webhooks", q)
+	result := []*TrelloWebhook{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+func (client *TrelloClient) CreateWebhook(modelID, callbackURL, description string) (*TrelloWebhook, error) {
+	q := map[string]string{
+		"idModel":     modelID,
+		"callbackURL": callbackURL,
+		"description": description,
+	}
+	result := &TrelloWebhook{}
+	err := client.post("/1/webhooks", q, result)
+	return result, err
+}
+
+func (client *TrelloClient) DeleteWebhook(webhookID string) error {
+	return client.delete("/1/webhooks/" + strings.TrimSpace(webhookID))
+}
+
+// registerWebhooks makes sure there is a webhook to the callback URL for
+// every board. It returns the webhooks it created, existing ones are kept.
+func (client *TrelloClient) registerWebhooks(boardIDs []string, callbackURL string) ([]*TrelloWebhook, error) {
+	existing, err := client.Webhooks()
+	if err != nil {
+		return nil, errors.New("Could not read webhooks: " + err.Error())
+	}
+	created := []*TrelloWebhook{}
+	for _, boardID := range boardIDs {
+		found := false
+		for _, hook := range existing {
+			found = found || (hook.IDModel == boardID && hook.CallbackURL == callbackURL)
+		}
+		if found {
+			continue
+		}
+		hook, err := client.CreateWebhook(boardID, callbackURL, "tres serve-webhooks")
+		if err != nil {
+			return created, errors.New("Could not create webhook for board " + NameFromID(boardID, client.TrelloBoards) + ": " + err.Error())
+		}
+		created = append(created, hook)
+	}
+	return created, nil
+}
+
+// ListenForWebhooks registers webhooks for the boards with the callback URL
+// and serves them on the listen address until the process is interrupted.
+// The webhooks it created are deleted again on interrupt.
+func (client *TrelloClient) ListenForWebhooks(listen, callbackURL string, boardIDs []string, listener WebhookListener) error {
+	secret := os.ExpandEnv("$TRELLO_SECRET")
+	if secret == "" {
+		return errors.New("TRELLO_SECRET environment variable not set, it is needed to verify webhook calls")
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Host == "" {
+		return errors.New("Invalid --public-url '" + callbackURL + "'")
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, &WebhookHandler{Secret: secret, CallbackURL: callbackURL, Listener: listener})
+	server := &http.Server{Addr: listen, Handler: mux}
+
+	// Trello calls the URL when the webhook is created, so we listen first
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.Serve(ln)
+	}()
+	created, err := client.registerWebhooks(boardIDs, callbackURL)
+	cleanup := func() {
+		for _, hook := range created {
+			if err := client.DeleteWebhook(hook.ID); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not delete webhook "+hook.ID+": "+err.Error())
+			}
+		}
+	}
+	if err != nil {
+		cleanup()
+		server.Close()
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Listening on "+listen+" for "+callbackURL)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	select {
+	case err = <-failed:
+	case <-interrupt:
+		err = server.Close()
+	}
+	cleanup()
+	return err
+}
+
+// eventWriter is the listener of the serve-webhooks command, it writes the
+// card changes like watch does
+type eventWriter struct {
+	client *TrelloClient
+	format string
+}
+
+func (w *eventWriter) HandleAction(payload *WebhookPayload) error {
+	boardName := payload.Action.Data.Board.BoardName
+	if boardName == "" {
+		boardName = payload.Model.Name
+	}
+	for _, event := range actionEvents(payload.Action, boardName) {
+		if err := w.client.emitEvent(event, w.format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeWebhooks registers webhooks for the boards given as arguments or with
+// --board and prints the card changes Trello sends.
+func (client *TrelloClient) ServeWebhooks() error {
+	format := strings.ToLower(client.config.Format)
+	if format != "text" && format != "json" {
+		return errors.New("Format not supported for this operation.")
+	}
+	if client.config.PublicURL == "" {
+		return errors.New("Missing --public-url, the URL Trello can reach this server at")
+	}
+	names := flag.Args()[1:]
+	if len(names) == 0 && client.config.BoardName != "" {
+		names = []string{client.config.BoardName}
+	}
+	if len(names) == 0 {
+		return errors.New("Missing board name")
+	}
+	boardIDs := []string{}
+	for _, name := range names {
+		boardID := IDFromName(name, client.TrelloBoards)
+		if boardID == "" {
+			if NameFromID(name, client.TrelloBoards) == "" {
+				return errors.New("Unknown board " + name)
+			}
+			boardID = name
+		}
+		boardIDs = append(boardIDs, boardID)
+	}
+	return client.ListenForWebhooks(client.config.Listen, client.config.PublicURL, boardIDs, &eventWriter{client, format})
+}
@@ -0,0 +1,140 @@
+package tres
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingListener struct {
+	payloads []*WebhookPayload
+	err      error
+}
+
+func (l *recordingListener) HandleAction(payload *WebhookPayload) error {
+	l.payloads = append(l.payloads, payload)
+	return l.err
+}
+
+const webhookBody = `{
+	"model": {"id": "b1", "name": "Team Board"},
+	"action": {
+		"id": "a1",
+		"type": "updateCard",
+		"date": "2026-10-18T09:30:00.000Z",
+		"data": {
+			"board": {"id": "b1", "name": "Team Board"},
+			"card": {"id": "c1", "name": "Fix login bug", "idList": "l2"},
+			"listBefore": {"id": "l1", "name": "Doing"},
+			"listAfter": {"id": "l2", "name": "Done"}
+		},
+		"memberCreator": {"username": "fred"}
+	}
+}`
+
+// newWebhookServer runs a WebhookHandler like serve-webhooks does, the
+// callback URL is the URL of the test server
+func newWebhookServer(listener WebhookListener) (*httptest.Server, *WebhookHandler) {
+	handler := &WebhookHandler{Secret: "secret", Listener: listener}
+	server := httptest.NewServer(handler)
+	handler.CallbackURL = server.URL + "/trello"
+	return server, handler
+}
+
+func postWebhook(t *testing.T, url, body, signature string) int {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != "" {
+		req.Header.Set("X-Trello-Webhook", signature)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWebhookHandler(t *testing.T) {
+	listener := &recordingListener{}
+	server, handler := newWebhookServer(listener)
+	defer server.Close()
+
+	signature := SignWebhookPayload("secret", []byte(webhookBody), handler.CallbackURL)
+	if status := postWebhook(t, handler.CallbackURL, webhookBody, signature); status != http.StatusOK {
+		t.Fatalf("signed payload: got status %d", status)
+	}
+	if len(listener.payloads) != 1 {
+		t.Fatalf("listener got %d payloads, want 1", len(listener.payloads))
+	}
+	payload := listener.payloads[0]
+	action := payload.Action
+	if payload.Model.Name != "Team Board" || action.Type != "updateCard" || action.Data.Card.CardName != "Fix login bug" ||
+		action.Data.ListBefore.ListName != "Doing" || action.Data.ListAfter.ListName != "Done" ||
+		action.MemberCreator.UserName != "fred" {
+		t.Errorf("unexpected payload %+v", action)
+	}
+}
+
+func TestWebhookHandlerRejectsBadSignatures(t *testing.T) {
+	listener := &recordingListener{}
+	server, handler := newWebhookServer(listener)
+	defer server.Close()
+
+	signatures := map[string]string{
+		"missing":       "",
+		"garbage":       "not a signature",
+		"wrong secret":  SignWebhookPayload("other", []byte(webhookBody), handler.CallbackURL),
+		"wrong URL":     SignWebhookPayload("secret", []byte(webhookBody), server.URL+"/other"),
+		"other payload": SignWebhookPayload("secret", []byte(`{"action": {}}`), handler.CallbackURL),
+	}
+	for name, signature := range signatures {
+		if status := postWebhook(t, handler.CallbackURL, webhookBody, signature); status != http.StatusUnauthorized {
+			t.Errorf("%s signature: got status %d, want %d", name, status, http.StatusUnauthorized)
+		}
+	}
+	if len(listener.payloads) != 0 {
+		t.Errorf("listener got %d payloads with bad signatures", len(listener.payloads))
+	}
+}
+
+func TestWebhookHandlerMethods(t *testing.T) {
+	listener := &recordingListener{}
+	server, handler := newWebhookServer(listener)
+	defer server.Close()
+
+	resp, err := http.Head(handler.CallbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HEAD: got status %d, want 200", resp.StatusCode)
+	}
+	resp, err = http.Get(handler.CallbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want 405", resp.StatusCode)
+	}
+	if len(listener.payloads) != 0 {
+		t.Errorf("listener got %d payloads without POST", len(listener.payloads))
+	}
+}
+
+func TestWebhookHandlerListenerError(t *testing.T) {
+	listener := &recordingListener{err: errors.New("failed")}
+	server, handler := newWebhookServer(listener)
+	defer server.Close()
+
+	signature := SignWebhookPayload("secret", []byte(webhookBody), handler.CallbackURL)
+	if status := postWebhook(t, handler.CallbackURL, webhookBody, signature); status != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", status)
+	}
+}
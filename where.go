@@ -0,0 +1,720 @@
+package tres
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// WhereSyntaxError reports a malformed --where expression. Pos is the
+// 1-based character position in the expression.
+type WhereSyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *WhereSyntaxError) Error() string {
+	return fmt.Sprintf("where syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindDate
+	kindBool
+)
+
+var fieldKindNames = map[fieldKind]string{
+	kindString: "text",
+	kindNumber: "number",
+	kindDate:   "date",
+	kindBool:   "boolean",
+}
+
+// fieldVal is a typed card field value, missing is set for empty dates
+// and numbers that cannot be computed
+type fieldVal struct {
+	kind    fieldKind
+	str     string
+	num     float64
+	date    time.Time
+	boolean bool
+	missing bool
+}
+
+// the kind of every card field that can be used in a where expression,
+// fields not listed here are not allowed
+var whereFields = map[string]fieldKind{
+	"attachmentcount":   kindNumber,
+	"checkitems":        kindNumber,
+	"checkitemschecked": kindNumber,
+	"checkedratio":      kindNumber,
+	"commentcount":      kindNumber,
+	"votes":             kindNumber,
+	"pos":               kindNumber,
+	"idshort":           kindNumber,
+	"inactivedays":      kindNumber,
+	"due":               kindDate,
+	"datelastactivity":  kindDate,
+	"created":           kindDate,
+	"closed":            kindBool,
+	"hasdesc":           kindBool,
+	"subscribed":        kindBool,
+	"duecomplete":       kindBool,
+	"overdue":           kindBool,
+	"id":                kindString,
+	"name":              kindString,
+	"desc":              kindString,
+	"email":             kindString,
+	"idboard":           kindString,
+	"idlist":            kindString,
+	"boardname":         kindString,
+	"listname":          kindString,
+	"labels":            kindString,
+	"labelcolors":       kindString,
+	"checked":           kindString,
+	"shortlink":         kindString,
+	"shorturl":          kindString,
+	"url":               kindString,
+	"idattachmentcover": kindString,
+	"comments":          kindString,
+	"idlabels":          kindString,
+	"idmembers":         kindString,
+	"idmembersvoted":    kindString,
+	"idchecklists":      kindString,
+	"members":           kindString,
+	"memberinitials":    kindString,
+	"voters":            kindString,
+	"checklistnames":    kindString,
+	"attachmentnames":   kindString,
+}
+
+func (client *TrelloClient) typedField(card *TrelloCardSearchResult, field string) fieldVal {
+	if isCustomField(field) {
+		_, val := client.customFieldValue(card, customFieldName(field))
+		return val
+	}
+	kind := whereFields[field]
+	val := fieldVal{kind: kind}
+	switch kind {
+	case kindNumber:
+		badges := card.Badges
+		if badges == nil {
+			badges = &TrelloBadges{}
+		}
+		switch field {
+		case "attachmentcount":
+			val.num = float64(badges.Attachments)
+		case "checkitems":
+			val.num = float64(badges.CheckItems)
+		case "checkitemschecked":
+			val.num = float64(badges.CheckItemsChecked)
+		case "checkedratio":
+			if badges.CheckItems == 0 {
+				val.missing = true
+			} else {
+				val.num = float64(badges.CheckItemsChecked) / float64(badges.CheckItems)
+			}
+		case "commentcount":
+			val.num = float64(badges.Comments)
+		case "votes":
+			val.num = float64(badges.Votes)
+		case "pos":
+			val.num = card.Pos
+		case "idshort":
+			val.num = float64(card.IDShort)
+		case "inactivedays":
+			days, ok := client.inactiveDays(card)
+			val.num, val.missing = float64(days), !ok
+		}
+	case kindDate:
+		var ok bool
+		switch field {
+		case "due":
+			val.date, ok = parseTrelloDate(card.Due)
+		case "datelastactivity":
+			val.date, ok = parseTrelloDate(card.DateLastActivity)
+		case "created":
+			val.date, ok = cardCreated(card.ID)
+		}
+		val.missing = !ok
+	case kindBool:
+		switch field {
+		case "closed":
+			val.boolean = card.Closed
+		case "hasdesc":
+			val.boolean = strings.TrimSpace(card.Desc) != ""
+		case "subscribed":
+			val.boolean = card.Subscribed
+		case "duecomplete":
+			val.boolean = card.DueComplete
+		case "overdue":
+			val.boolean = isOverdue(card, client.Now())
+		}
+	default:
+		val.str = client.fieldValue(card, field)
+	}
+	return val
+}
+
+type whereTokenKind int
+
+const (
+	whereWord whereTokenKind = iota
+	whereString
+	whereOp
+	whereOpen
+	whereClose
+	whereEOF
+)
+
+type whereToken struct {
+	kind whereTokenKind
+	text string
+	pos  int
+}
+
+func tokenizeWhere(expr string) ([]whereToken, error) {
+	input := []rune(expr)
+	tokens := []whereToken{}
+	for i := 0; i < len(input); {
+		r := input[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			kind := whereOpen
+			if r == ')' {
+				kind = whereClose
+			}
+			tokens = append(tokens, whereToken{kind, string(r), i + 1})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(input) && input[j] != r {
+				j++
+			}
+			if j >= len(input) {
+				return nil, &WhereSyntaxError{i + 1, "unterminated string"}
+			}
+			tokens = append(tokens, whereToken{whereString, string(input[i+1 : j]), i + 1})
+			i = j + 1
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(input) && (input[j] == '=' || (r == '<' && input[j] == '>')) {
+				j++
+			}
+			op := string(input[i:j])
+			if op == "!" {
+				return nil, &WhereSyntaxError{i + 1, "unknown operator '!'"}
+			}
+			tokens = append(tokens, whereToken{whereOp, op, i + 1})
+			i = j
+		default:
+			j := i
+			for j < len(input) && !unicode.IsSpace(input[j]) && !strings.ContainsRune("()=!<>\"'", input[j]) {
+				j++
+			}
+			tokens = append(tokens, whereToken{whereWord, string(input[i:j]), i + 1})
+			i = j
+		}
+	}
+	tokens = append(tokens, whereToken{whereEOF, "", len(input) + 1})
+	return tokens, nil
+}
+
+// WhereExpr is a parsed --where filter expression.
+type WhereExpr struct {
+	Text string
+	root whereNode
+}
+
+type whereNode interface {
+	eval(client *TrelloClient, card *TrelloCardSearchResult) bool
+}
+
+type whereAnd struct{ left, right whereNode }
+type whereOr struct{ left, right whereNode }
+type whereNot struct{ node whereNode }
+
+func (n *whereAnd) eval(client *TrelloClient, card *TrelloCardSearchResult) bool {
+	return n.left.eval(client, card) && n.right.eval(client, card)
+}
+
+func (n *whereOr) eval(client *TrelloClient, card *TrelloCardSearchResult) bool {
+	return n.left.eval(client, card) || n.right.eval(client, card)
+}
+
+func (n *whereNot) eval(client *TrelloClient, card *TrelloCardSearchResult) bool {
+	return !n.node.eval(client, card)
+}
+
+// whereOperand is a literal on the right side of a comparison. Dates are
+// kept as expressions because "today" depends on when they are evaluated.
+type whereOperand struct {
+	num     float64
+	str     string
+	boolean bool
+	date    *dateExpr
+}
+
+type whereCompare struct {
+	field   string
+	kind    fieldKind
+	op      string
+	values  []whereOperand
+	pattern *regexp.Regexp
+}
+
+type whereParser struct {
+	client   *TrelloClient
+	tokens   []whereToken
+	pos      int
+	boardIDs []string // boards to look up custom field types
+}
+
+func (p *whereParser) tok() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) isKeyword(word string) bool {
+	t := p.tok()
+	return t.kind == whereWord && strings.ToLower(t.text) == word
+}
+
+// ParseWhere parses a filter expression like
+// `commentcount > 3 and (due before today+7d or labels contains "urgent")`.
+func (client *TrelloClient) ParseWhere(expr string) (*WhereExpr, error) {
+	boardIDs := []string{}
+	for _, board := range client.TrelloBoards {
+		boardIDs = append(boardIDs, board.ID)
+	}
+	return client.parseWhere(expr, boardIDs)
+}
+
+func (client *TrelloClient) parseWhere(expr string, boardIDs []string) (*WhereExpr, error) {
+	tokens, err := tokenizeWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{client: client, tokens: tokens, boardIDs: boardIDs}
+	if p.tok().kind == whereEOF {
+		return nil, &WhereSyntaxError{1, "empty expression"}
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.tok(); t.kind != whereEOF {
+		return nil, &WhereSyntaxError{t.pos, "unexpected '" + t.text + "'"}
+	}
+	return &WhereExpr{Text: expr, root: root}, nil
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isKeyword("or") {
+		p.pos++
+		var right whereNode
+		right, err = p.parseAnd()
+		left = &whereOr{left, right}
+	}
+	return left, err
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseNot()
+	for err == nil && p.isKeyword("and") {
+		p.pos++
+		var right whereNode
+		right, err = p.parseNot()
+		left = &whereAnd{left, right}
+	}
+	return left, err
+}
+
+func (p *whereParser) parseNot() (whereNode, error) {
+	t := p.tok()
+	switch {
+	case p.isKeyword("not"):
+		p.pos++
+		node, err := p.parseNot()
+		return &whereNot{node}, err
+	case t.kind == whereOpen:
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok().kind != whereClose {
+			return nil, &WhereSyntaxError{t.pos, "missing ')' for this '('"}
+		}
+		p.pos++
+		return node, nil
+	case t.kind == whereWord:
+		return p.parseComparison()
+	case t.kind == whereEOF:
+		return nil, &WhereSyntaxError{t.pos, "unexpected end of expression"}
+	}
+	return nil, &WhereSyntaxError{t.pos, "expected field name, found '" + t.text + "'"}
+}
+
+func (p *whereParser) parseField() (string, fieldKind, error) {
+	t := p.tok()
+	field := strings.ToLower(t.text)
+	if isCustomField(field) {
+		name := t.text[len(customFieldPrefix):]
+		if name == "" && p.tokens[p.pos+1].kind == whereString {
+			// cf:"Story Points"
+			p.pos++
+			name = p.tok().text
+		}
+		if name == "" {
+			return "", kindString, &WhereSyntaxError{t.pos, "missing custom field name after cf:"}
+		}
+		kind, err := p.client.customFieldKindOf(name, p.boardIDs)
+		if err != nil {
+			return "", kind, &WhereSyntaxError{t.pos, err.Error()}
+		}
+		p.pos++
+		return customFieldPrefix + name, kind, nil
+	}
+	kind, ok := whereFields[field]
+	if !ok {
+		return "", kind, &WhereSyntaxError{t.pos, "unknown field '" + t.text + "'"}
+	}
+	p.pos++
+	return field, kind, nil
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	fieldTok := p.tok()
+	field, kind, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	cmp := &whereCompare{field: field, kind: kind}
+
+	t := p.tok()
+	op := strings.ToLower(t.text)
+	if t.kind == whereOp {
+		cmp.op = op
+		if op == "==" {
+			cmp.op = "="
+		} else if op == "<>" {
+			cmp.op = "!="
+		}
+	} else if t.kind == whereWord && (op == "between" || op == "matches" || op == "contains" || op == "before" || op == "after") {
+		cmp.op = op
+	} else if kind == kindBool {
+		// a boolean field on its own, e.g. "not closed"
+		cmp.op = "="
+		cmp.values = []whereOperand{{boolean: true}}
+		return cmp, nil
+	} else if t.kind == whereEOF {
+		return nil, &WhereSyntaxError{t.pos, "missing operator after '" + fieldTok.text + "'"}
+	} else {
+		return nil, &WhereSyntaxError{t.pos, "unknown operator '" + t.text + "'"}
+	}
+	p.pos++
+
+	switch cmp.op {
+	case "matches":
+		t = p.tok()
+		if t.kind != whereString && t.kind != whereWord {
+			return nil, &WhereSyntaxError{t.pos, "expected regular expression after matches"}
+		}
+		cmp.pattern, err = regexp.Compile("(?i)" + t.text)
+		if err != nil {
+			return nil, &WhereSyntaxError{t.pos, "invalid regular expression: " + err.Error()}
+		}
+		p.pos++
+		return cmp, nil
+	case "contains":
+		if kind != kindString {
+			return nil, &WhereSyntaxError{t.pos, "contains needs a text field, '" + field + "' is a " + fieldKindNames[kind]}
+		}
+	case "before", "after":
+		if kind != kindDate {
+			return nil, &WhereSyntaxError{t.pos, cmp.op + " needs a date field, '" + field + "' is a " + fieldKindNames[kind]}
+		}
+	case "between":
+		if kind != kindNumber && kind != kindDate {
+			return nil, &WhereSyntaxError{t.pos, "between needs a number or date field, '" + field + "' is a " + fieldKindNames[kind]}
+		}
+	case "<", "<=", ">", ">=":
+		if kind == kindBool {
+			return nil, &WhereSyntaxError{t.pos, "cannot use " + cmp.op + " on boolean field '" + field + "'"}
+		}
+	}
+
+	val, err := p.parseOperand(kind)
+	if err != nil {
+		return nil, err
+	}
+	cmp.values = append(cmp.values, val)
+	if cmp.op == "between" {
+		if !p.isKeyword("and") {
+			return nil, &WhereSyntaxError{p.tok().pos, "expected 'and' in between"}
+		}
+		p.pos++
+		if val, err = p.parseOperand(kind); err != nil {
+			return nil, err
+		}
+		cmp.values = append(cmp.values, val)
+	}
+	return cmp, nil
+}
+
+func (p *whereParser) parseOperand(kind fieldKind) (whereOperand, error) {
+	t := p.tok()
+	val := whereOperand{}
+	if t.kind != whereWord && t.kind != whereString {
+		return val, &WhereSyntaxError{t.pos, "expected a value"}
+	}
+	p.pos++
+	text := t.text
+	switch kind {
+	case kindNumber:
+		percent := strings.HasSuffix(text, "%")
+		n, err := strconv.ParseFloat(strings.TrimSuffix(text, "%"), 64)
+		if err != nil {
+			return val, &WhereSyntaxError{t.pos, "expected a number, found '" + text + "'"}
+		}
+		if percent {
+			n = n / 100
+		}
+		val.num = n
+	case kindDate:
+		d, err := parseDateExpr(text)
+		if err != nil {
+			return val, &WhereSyntaxError{t.pos, err.Error()}
+		}
+		val.date = d
+	case kindBool:
+		switch strings.ToLower(text) {
+		case "true", "yes":
+			val.boolean = true
+		case "false", "no":
+		default:
+			return val, &WhereSyntaxError{t.pos, "expected true or false, found '" + text + "'"}
+		}
+	default:
+		val.str = text
+	}
+	return val, nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (n *whereCompare) eval(client *TrelloClient, card *TrelloCardSearchResult) bool {
+	v := client.typedField(card, n.field)
+	if n.op == "matches" {
+		s := v.str
+		switch v.kind {
+		case kindNumber:
+			s = strconv.FormatFloat(v.num, 'f', -1, 64)
+		case kindDate:
+			s = client.fieldValue(card, n.field)
+		case kindBool:
+			s = strconv.FormatBool(v.boolean)
+		}
+		return !v.missing && n.pattern.MatchString(s)
+	}
+	if v.missing {
+		return n.op == "!="
+	}
+
+	var now time.Time
+	if v.kind == kindDate {
+		now = client.Now()
+	}
+	compare := func(operand whereOperand) int {
+		switch v.kind {
+		case kindNumber:
+			if n.field == "checkedratio" {
+				// avoid 1/3 not being equal to 33.3%
+				return compareFloat(math.Round(v.num*1000), math.Round(operand.num*1000))
+			}
+			return compareFloat(v.num, operand.num)
+		case kindDate:
+			return operand.date.compare(v.date, now)
+		case kindBool:
+			if v.boolean == operand.boolean {
+				return 0
+			}
+			return 1
+		}
+		return strings.Compare(strings.ToLower(v.str), strings.ToLower(operand.str))
+	}
+
+	switch n.op {
+	case "=":
+		return compare(n.values[0]) == 0
+	case "!=":
+		return compare(n.values[0]) != 0
+	case "<", "before":
+		return compare(n.values[0]) < 0
+	case "<=":
+		return compare(n.values[0]) <= 0
+	case ">", "after":
+		return compare(n.values[0]) > 0
+	case ">=":
+		return compare(n.values[0]) >= 0
+	case "between":
+		return compare(n.values[0]) >= 0 && compare(n.values[1]) <= 0
+	case "contains":
+		return strings.Contains(strings.ToLower(v.str), strings.ToLower(n.values[0].str))
+	}
+	return false
+}
+
+// Match reports whether the card satisfies the expression.
+func (w *WhereExpr) Match(client *TrelloClient, card *TrelloCardSearchResult) bool {
+	return w.root.eval(client, card)
+}
+
+// dateExpr is a point in time like "2015-08-14", "today+7d" or "now-12h".
+// Relative expressions are resolved at evaluation time, a value without
+// a time of day compares against the whole day.
+type dateExpr struct {
+	base   string // today, now or empty for an absolute date
+	abs    time.Time
+	offset int
+	unit   byte // d, w, m or h
+	hasDay bool // expression denotes a whole day rather than an instant
+}
+
+func parseDateExpr(text string) (*dateExpr, error) {
+	lower := strings.ToLower(text)
+	d := &dateExpr{}
+	for _, base := range []string{"today", "yesterday", "tomorrow", "now"} {
+		if strings.HasPrefix(lower, base) {
+			d.base = base
+			d.hasDay = base != "now"
+			rest := lower[len(base):]
+			if rest == "" {
+				return d, nil
+			}
+			if rest[0] != '+' && rest[0] != '-' || len(rest) < 3 {
+				return nil, fmt.Errorf("invalid date offset in '%s', use e.g. %s+7d", text, base)
+			}
+			d.unit = rest[len(rest)-1]
+			n, err := strconv.Atoi(rest[1 : len(rest)-1])
+			if err != nil || !strings.ContainsRune("dwmh", rune(d.unit)) {
+				return nil, fmt.Errorf("invalid date offset in '%s', use a number followed by d, w, m or h", text)
+			}
+			if rest[0] == '-' {
+				n = -n
+			}
+			d.offset = n
+			if d.unit == 'h' {
+				d.hasDay = false
+			}
+			return d, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", text, time.Local); err == nil {
+		d.abs, d.hasDay = t, true
+		return d, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		d.abs = t
+		return d, nil
+	}
+	return nil, fmt.Errorf("invalid date '%s', use YYYY-MM-DD, an ISO timestamp or today/now with an offset like today+7d", text)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// resolve returns the instant the expression denotes, relative to now
+func (d *dateExpr) resolve(now time.Time) time.Time {
+	t := d.abs
+	switch d.base {
+	case "now":
+		t = now
+	case "today":
+		t = startOfDay(now)
+	case "yesterday":
+		t = startOfDay(now).AddDate(0, 0, -1)
+	case "tomorrow":
+		t = startOfDay(now).AddDate(0, 0, 1)
+	}
+	switch d.unit {
+	case 'h':
+		t = t.Add(time.Duration(d.offset) * time.Hour)
+	case 'd':
+		t = t.AddDate(0, 0, d.offset)
+	case 'w':
+		t = t.AddDate(0, 0, 7*d.offset)
+	case 'm':
+		t = t.AddDate(0, d.offset, 0)
+	}
+	return t
+}
+
+// compare returns -1, 0 or 1 if t is before, within or after the expression
+func (d *dateExpr) compare(t, now time.Time) int {
+	start := d.resolve(now)
+	if d.hasDay {
+		t = t.In(start.Location())
+		end := start.AddDate(0, 0, 1)
+		switch {
+		case t.Before(start):
+			return -1
+		case !t.Before(end):
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case t.Before(start):
+		return -1
+	case t.After(start):
+		return 1
+	}
+	return 0
+}
+
+// applyWhere filters the cards by the --where expression, if any
+func (client *TrelloClient) applyWhere(cards []*TrelloCardSearchResult) ([]*TrelloCardSearchResult, error) {
+	if strings.TrimSpace(client.config.Where) == "" {
+		return cards, nil
+	}
+	// custom field types only need to be looked up on the boards in the result
+	boardIDs := []string{}
+	seen := map[string]bool{}
+	for _, card := range cards {
+		if !seen[card.IDBoard] {
+			seen[card.IDBoard] = true
+			boardIDs = append(boardIDs, card.IDBoard)
+		}
+	}
+	expr, err := client.parseWhere(client.config.Where, boardIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := []*TrelloCardSearchResult{}
+	for _, card := range cards {
+		if expr.Match(client, card) {
+			result = append(result, card)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,365 @@
+package tres
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// MemberWorkload is the work assigned to a member on one or more boards
+type MemberWorkload struct {
+	IDMember          string `json:"idMember"`
+	UserName          string `json:"username"`
+	FullName          string `json:"fullName"`
+	OpenCards         int    `json:"openCards"`
+	Overdue           int    `json:"overdue"`
+	PendingCheckItems int    `json:"pendingCheckItems"`
+	RecentActions     int    `json:"recentActions"`
+}
+
+var workloadHeader = []string{"member", "full name", "open cards", "overdue", "pending items", "actions"}
+
+func (w *MemberWorkload) columns() []string {
+	return []string{
+		w.UserName,
+		w.FullName,
+		strconv.Itoa(w.OpenCards),
+		strconv.Itoa(w.Overdue),
+		strconv.Itoa(w.PendingCheckItems),
+		strconv.Itoa(w.RecentActions),
+	}
+}
+
+func (client *TrelloClient) MemberBoards(memberID, filter string) (TrelloNameList, error) {
+	q := map[string]string{
+		"fields": "name",
+		"filter": filter,
+	}
+	theURL := client.prepareQuery("/1/members/"+strings.TrimSpace(memberID)+"/boards", q)
+	return client.TrelloNamesFromURL(theURL.String())
+}
+
+// MemberCards returns the cards a member is assigned to, filter is open,
+// closed or all.
+func (client *TrelloClient) MemberCards(memberID, filter string) ([]*TrelloCardSearchResult, error) {
+	q := map[string]string{
+		"filter": filter,
+	}
+	theURL := client.prepareQuery("/1/members/"+strings.TrimSpace(memberID)+"/cards", q)
+	result := []*TrelloCardSearchResult{}
+	resp, err := client.HTTPClient.Get(theURL.String())
+	err = processResponse(resp, err, &result)
+	return result, err
+}
+
+// workloadBoards returns the boards for the workload command, the last
+// argument is a board name or "all" for all boards of the user
+func (client *TrelloClient) workloadBoards() (TrelloNameList, error) {
+	if flag.NArg() >= 2 && strings.EqualFold(flag.Arg(flag.NArg()-1), "all") {
+		return client.TrelloBoards, nil
+	}
+	boardID, boardName, err := client.boardFromArg()
+	if err != nil {
+		return nil, err
+	}
+	return TrelloNameList{{ID: boardID, Name: boardName}}, nil
+}
+
+// workload sums the open cards and the actions since --since per member.
+// Open cards without members are reported as member (none).
+func (client *TrelloClient) workload(boards TrelloNameList, since time.Time) ([]*MemberWorkload, error) {
+	now := client.Now()
+	index := map[string]*MemberWorkload{}
+	get := func(boardID, memberID string) *MemberWorkload {
+		w, ok := index[memberID]
+		if !ok {
+			w = &MemberWorkload{IDMember: memberID, UserName: memberID}
+			if memberID == "" {
+				w.UserName = noGroupValue
+			} else if member := client.memberByID(boardID, memberID); member != nil {
+				w.UserName, w.FullName = member.UserName, member.FullName
+			}
+			index[memberID] = w
+		}
+		return w
+	}
+
+	for _, board := range boards {
+		for _, member := range client.directoryMembers(board.ID) {
+			get(board.ID, member.IDMember)
+		}
+		cards, err := client.BoardCards(board.ID, "open")
+		if err != nil {
+			return nil, errors.New("Could not read cards of board " + board.Name + ": " + err.Error())
+		}
+		for _, card := range cards {
+			ids := card.IDMembers
+			if len(ids) == 0 {
+				ids = []string{""}
+			}
+			for _, id := range ids {
+				w := get(board.ID, id)
+				w.OpenCards++
+				if isOverdue(card, now) {
+					w.Overdue++
+				}
+				if card.Badges != nil {
+					w.PendingCheckItems += card.Badges.CheckItems - card.Badges.CheckItemsChecked
+				}
+			}
+		}
+		actions, err := client.BoardActions(board.ID, "all", since)
+		if err != nil {
+			return nil, errors.New("Could not read actions of board " + board.Name + ": " + err.Error())
+		}
+		for _, action := range actions {
+			if action.IDMemberCreator == "" {
+				continue
+			}
+			w := get(board.ID, action.IDMemberCreator)
+			if w.UserName == w.IDMember && action.MemberCreator.UserName != "" {
+				w.UserName, w.FullName = action.MemberCreator.UserName, action.MemberCreator.FullName
+			}
+			w.RecentActions++
+		}
+	}
+
+	result := []*MemberWorkload{}
+	for _, w := range index {
+		result = append(result, w)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if (a.IDMember == "") != (b.IDMember == "") {
+			return b.IDMember == ""
+		}
+		if a.OpenCards != b.OpenCards {
+			return a.OpenCards > b.OpenCards
+		}
+		return strings.ToLower(a.UserName) < strings.ToLower(b.UserName)
+	})
+	return result, nil
+}
+
+func (client *TrelloClient) workloadFormatterText(title string, workload []*MemberWorkload) error {
+	rows := [][]string{workloadHeader}
+	for _, w := range workload {
+		rows = append(rows, w.columns())
+	}
+	widths := make([]int, len(workloadHeader))
+	for _, row := range rows {
+		for i, col := range row {
+			if n := len([]rune(col)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	fmt.Fprintln(client.out, title)
+	fmt.Fprintln(client.out)
+	for r, row := range rows {
+		fmt.Fprintln(client.out, strings.TrimRight(strings.Join(formatColumns(row, widths), "  "), " "))
+		if r == 0 {
+			fmt.Fprintln(client.out, strings.Repeat("-", len(strings.Join(formatColumns(row, widths), "  "))))
+		}
+	}
+	return nil
+}
+
+func (client *TrelloClient) workloadFormatterCsv(workload []*MemberWorkload) error {
+	fmt.Fprintln(client.out, strings.Join(workloadHeader, client.config.ColSep))
+	for _, w := range workload {
+		cols := w.columns()
+		if client.config.QuoteChar != "" {
+			for i := range cols {
+				cols[i] = client.config.QuoteChar + cols[i] + client.config.QuoteChar
+			}
+		}
+		fmt.Fprint(client.out, strings.Join(cols, client.config.ColSep))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return nil
+}
+
+func (client *TrelloClient) workloadFormatterJSON(boards TrelloNameList, since time.Time, workload []*MemberWorkload) error {
+	doc, err := json.Marshal(struct {
+		Boards  TrelloNameList    `json:"boards"`
+		Since   string            `json:"since"`
+		Members []*MemberWorkload `json:"members"`
+	}{boards, since.Format(time.RFC3339), workload})
+	if err == nil {
+		fmt.Fprint(client.out, string(doc))
+		fmt.Fprint(client.out, client.config.RowSep)
+	}
+	return err
+}
+
+func (client *TrelloClient) workloadFormatterMarkdown(title string, workload []*MemberWorkload) error {
+	fmt.Fprintln(client.out, "# "+title)
+	fmt.Fprintln(client.out)
+	fmt.Fprintln(client.out, "| "+strings.Join(workloadHeader, " | ")+" |")
+	fmt.Fprintln(client.out, "|---|---"+strings.Repeat("|--:", len(workloadHeader)-2)+"|")
+	for _, w := range workload {
+		fmt.Fprintln(client.out, "| "+strings.Join(w.columns(), " | ")+" |")
+	}
+	fmt.Fprint(client.out, client.config.RowSep)
+	return nil
+}
+
+func (client *TrelloClient) workloadFormatterExcel(workload []*MemberWorkload) (err error) {
+	rows := [][]string{workloadHeader}
+	for _, w := range workload {
+		rows = append(rows, w.columns())
+	}
+	file := xlsx.NewFile()
+	if err = addSheetRows(file, "Workload", rows); err != nil {
+		return
+	}
+	return file.Write(client.out)
+}
+
+// Workload prints per member the open cards, overdue cards, unchecked
+// checklist items and the number of actions since --since.
+func (client *TrelloClient) Workload() error {
+	boards, err := client.workloadBoards()
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(client.config.Since, client.Now())
+	if err != nil {
+		return err
+	}
+	workload, err := client.workload(boards, since)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, board := range boards {
+		names = append(names, board.Name)
+	}
+	title := "Workload on " + strings.Join(names, ", ") + ", actions since " + since.Format("2006-01-02")
+	switch strings.ToLower(client.config.Format) {
+	case "text":
+		err = client.workloadFormatterText(title, workload)
+	case "csv":
+		err = client.workloadFormatterCsv(workload)
+	case "json":
+		err = client.workloadFormatterJSON(boards, since, workload)
+	case "excel":
+		err = client.workloadFormatterExcel(workload)
+	case "markdown":
+		err = client.workloadFormatterMarkdown(title, workload)
+	default:
+		err = errors.New("INVALID_OUTPUT_FORMAT")
+	}
+	return err
+}
+
+// memberCardsFormatterExcel writes the boards on the first sheet and a sheet of
+// cards per board
+func (client *TrelloClient) memberCardsFormatterExcel(boards TrelloNameList, groups []*CardGroup) (err error) {
+	client.config.QuoteChar = "" // we do not need quoting in excel
+	rows := [][]string{{"board", "id"}}
+	for _, board := range boards {
+		rows = append(rows, []string{board.Name, board.ID})
+	}
+	file := xlsx.NewFile()
+	used := map[string]bool{"boards": true}
+	if err = addSheetRows(file, "Boards", rows); err != nil {
+		return
+	}
+	for _, group := range groups {
+		if err = client.addCardSheet(file, group.Name, group.Cards, used); err != nil {
+			return
+		}
+	}
+	return file.Write(client.out)
+}
+
+// Member shows the boards of a member and the open cards the member is
+// assigned to, grouped by board unless --group-by is given.
+func (client *TrelloClient) Member() error {
+	if flag.NArg() < 2 {
+		return errors.New("Missing member user name")
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(flag.Arg(flag.NArg()-1)), "@")
+	member, err := client.FetchMember(name)
+	if err != nil {
+		return errors.New("Unknown member " + name + ": " + err.Error())
+	}
+	boards, err := client.MemberBoards(member.IDMember, "open")
+	if err != nil {
+		return err
+	}
+	cards, err := client.MemberCards(member.IDMember, "open")
+	if err == nil {
+		cards, err = client.applyWhere(cards)
+	}
+	if err != nil {
+		return errors.New("Could not read cards of member " + name + ": " + err.Error())
+	}
+	for _, board := range boards {
+		if IDFromName(board.Name, client.TrelloBoards) == "" {
+			// boards of other members are not in the board info
+			client.TrelloBoards = append(client.TrelloBoards, board)
+		}
+	}
+	grouped := client.isGrouped()
+	if !grouped {
+		client.config.GroupBy = "boardname"
+	}
+
+	format := strings.ToLower(client.config.Format)
+	switch format {
+	case "text":
+		fmt.Fprintln(client.out, "@"+member.UserName+" "+member.FullName)
+		fmt.Fprintln(client.out)
+		fmt.Fprintln(client.out, "Boards:")
+		for _, board := range boards {
+			fmt.Fprintln(client.out, "    "+board.Name)
+		}
+		fmt.Fprintln(client.out)
+	case "markdown":
+		fmt.Fprintln(client.out, "# @"+member.UserName+" "+member.FullName)
+		fmt.Fprintln(client.out)
+		fmt.Fprintln(client.out, "## Boards")
+		fmt.Fprintln(client.out)
+		for _, board := range boards {
+			fmt.Fprintln(client.out, "* "+board.Name)
+		}
+		fmt.Fprintln(client.out)
+	case "json", "excel":
+		if err = client.sortCards(cards); err != nil {
+			return err
+		}
+		var value interface{} = cards
+		if format == "excel" || grouped {
+			// json has the group of every card only with --group-by
+			groups, err := client.groupCards(cards)
+			if err != nil {
+				return err
+			}
+			if format == "excel" {
+				return client.memberCardsFormatterExcel(boards, groups)
+			}
+			value = client.jsonCards(groups)
+		}
+		doc, err := json.Marshal(struct {
+			Member *TrelloMember  `json:"member"`
+			Boards TrelloNameList `json:"boards"`
+			Cards  interface{}    `json:"cards"`
+		}{member, boards, value})
+		if err == nil {
+			fmt.Fprint(client.out, string(doc))
+			fmt.Fprint(client.out, client.config.RowSep)
+		}
+		return err
+	}
+	return client.outputCards(cards, format)
+}